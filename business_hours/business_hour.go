@@ -7,9 +7,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/subiz/goutils/clock"
 	pb "github.com/subiz/header/account"
 )
 
+// DuringBusinessHourNow tells whether the current time of clock c (default
+// to clock.Real) is in business hours bh, tz is a timezone offset
+func DuringBusinessHourNow(bh *pb.BusinessHours, c clock.Clock, tz string) (bool, error) {
+	return DuringBusinessHour(bh, clock.OrReal(c).Now(), tz)
+}
+
 func DuringBusinessHour(bh *pb.BusinessHours, date time.Time, tz string) (bool, error) {
 	isholiday, err := IsHoliday(bh, date, tz)
 	if err != nil {
@@ -1,6 +1,9 @@
 package business_hours
 
 import (
+	"fmt"
+	"github.com/subiz/goutils/clock"
+	"github.com/subiz/goutils/expression"
 	pb "github.com/subiz/header/account"
 	"testing"
 	"time"
@@ -143,3 +146,42 @@ func S(s interface{}) *string {
 		return &str
 	}
 }
+
+func TestDuringBusinessHoursExpression(t *testing.T) {
+	c := clock.NewFakeClock(time.Date(2018, 8, 21, 5, 4, 0, 0, time.UTC)) // Tuesday
+
+	bh := &pb.BusinessHours{WorkingDays: []*pb.BusinessHours_WorkingDay{{
+		Weekday:   S("Tuesday"),
+		StartTime: S("08:30"),
+		EndTime:   S("23:30"),
+	}}}
+	env := expression.Map{"account": map[string]any{"business_hours": bh, "timezone": "Asia/Ho_Chi_Minh"}}
+	tcs := []struct {
+		exp string
+		in  bool
+	}{
+		{`duringBusinessHours(account.business_hours, "+07:00")`, true},
+		{`duringBusinessHours(account.business_hours, account.timezone)`, true},
+		{`duringBusinessHours(account.business_hours, tzOffset("Asia/Ho_Chi_Minh"))`, true},
+		{`duringBusinessHours(account.business_hours, "+00:00")`, false},
+	}
+	for _, tc := range tcs {
+		in, err := expression.MustCompile(tc.exp).WithClock(c).Eval(env)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.exp, err)
+		}
+		if in != tc.in {
+			t.Errorf("%s: should be %v, got %v", tc.exp, tc.in, in)
+		}
+	}
+
+	// 23:34 in Ho Chi Minh city
+	c.Advance(11*time.Hour + 30*time.Minute)
+	p := expression.MustCompile(`duringBusinessHours(account.business_hours, "+07:00")`)
+	if in, err := p.WithClock(c).Eval(env); err != nil || in {
+		t.Errorf("should be closed, got %v %v", in, err)
+	}
+	if in, err := DuringBusinessHourNow(bh, c, "+07:00"); err != nil || in {
+		t.Errorf("should be closed, got %v %v", in, err)
+	}
+}
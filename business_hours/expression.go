@@ -0,0 +1,41 @@
+package business_hours
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+	"github.com/subiz/goutils/expression"
+	pb "github.com/subiz/header/account"
+)
+
+// registers duringBusinessHours(bh, tz) expression function, which tells
+// whether the current time is in business hours bh. tz could be an offset
+// (+07:00) or an IANA timezone name (Asia/Ho_Chi_Minh)
+func init() {
+	err := expression.Register(&expression.Func{
+		Name:   "duringBusinessHours",
+		Params: []expression.Kind{expression.KindObject, expression.KindString},
+		Result: expression.KindBool,
+		CallNow: func(now time.Time, args []expression.Value) (expression.Value, error) {
+			bh, ok := args[0].AsObject().(*pb.BusinessHours)
+			if !ok {
+				return expression.Null, fmt.Errorf("want *account.BusinessHours, got %T", args[0].AsObject())
+			}
+			tz := args[1].AsString()
+			if strings.Contains(tz, "/") {
+				// the offset at now, which changes with daylight saving time
+				tz = clock.TimezoneToUTCAt(tz, now)
+			}
+			during, err := DuringBusinessHour(bh, now, tz)
+			if err != nil {
+				return expression.Null, err
+			}
+			return expression.Bool(during), nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,167 @@
+package clock
+
+import "time"
+
+// Calendar helpers work on timestamps t which could be nanosecond,
+// millisecond, microsecond or second (see Guess) and on a timezone tz,
+// a name (e.g: "America/New_York") or an offset (e.g: "+07:00"), see
+// LoadLocation. Boundaries are returned in nanoseconds, the end of a period is
+// its last nanosecond.
+
+// inTimezone converts timestamp t to a time in timezone tz
+func inTimezone(t int64, tz string) (time.Time, error) {
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return Guess(t).Time().In(loc), nil
+}
+
+// startOf returns the start of the period of t in timezone tz, start
+// truncates a time to the date starting its period
+func startOf(t int64, tz string, start func(time.Time) time.Time) (int64, error) {
+	tt, err := inTimezone(t, tz)
+	if err != nil {
+		return 0, err
+	}
+	return start(tt).UnixNano(), nil
+}
+
+// endOf returns the last nanosecond of the period of t in timezone tz, next
+// returns the start of the following period
+func endOf(t int64, tz string, start, next func(time.Time) time.Time) (int64, error) {
+	tt, err := inTimezone(t, tz)
+	if err != nil {
+		return 0, err
+	}
+	return next(start(tt)).UnixNano() - 1, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func nextDay(t time.Time) time.Time { return startOfDay(t.AddDate(0, 0, 1)) }
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func nextMonth(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+
+func startOfQuarter(t time.Time) time.Time {
+	month := (t.Month()-1)/3*3 + 1
+	return time.Date(t.Year(), month, 1, 0, 0, 0, 0, t.Location())
+}
+
+func nextQuarter(t time.Time) time.Time { return t.AddDate(0, 3, 0) }
+
+func startOfYear(t time.Time) time.Time {
+	return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+}
+
+func nextYear(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+
+// StartOfDay returns the midnight starting the day of t in timezone tz
+func StartOfDay(t int64, tz string) (int64, error) { return startOf(t, tz, startOfDay) }
+
+// EndOfDay returns the last nanosecond of the day of t in timezone tz
+func EndOfDay(t int64, tz string) (int64, error) { return endOf(t, tz, startOfDay, nextDay) }
+
+// StartOfWeek returns the midnight starting the week of t in timezone tz,
+// weeks start on weekStart, e.g: time.Monday
+func StartOfWeek(t int64, tz string, weekStart time.Weekday) (int64, error) {
+	return startOf(t, tz, func(t time.Time) time.Time { return startOfWeek(t, weekStart) })
+}
+
+// EndOfWeek returns the last nanosecond of the week of t in timezone tz,
+// weeks start on weekStart, e.g: time.Monday
+func EndOfWeek(t int64, tz string, weekStart time.Weekday) (int64, error) {
+	return endOf(t, tz, func(t time.Time) time.Time { return startOfWeek(t, weekStart) },
+		func(t time.Time) time.Time { return startOfDay(t.AddDate(0, 0, 7)) })
+}
+
+func startOfWeek(t time.Time, weekStart time.Weekday) time.Time {
+	days := (int(t.Weekday()) - int(weekStart) + 7) % 7
+	return startOfDay(t.AddDate(0, 0, -days))
+}
+
+// StartOfMonth returns the midnight starting the month of t in timezone tz
+func StartOfMonth(t int64, tz string) (int64, error) { return startOf(t, tz, startOfMonth) }
+
+// EndOfMonth returns the last nanosecond of the month of t in timezone tz
+func EndOfMonth(t int64, tz string) (int64, error) {
+	return endOf(t, tz, startOfMonth, nextMonth)
+}
+
+// StartOfQuarter returns the midnight starting the quarter of t in timezone
+// tz, quarters start in January, April, July and October
+func StartOfQuarter(t int64, tz string) (int64, error) { return startOf(t, tz, startOfQuarter) }
+
+// EndOfQuarter returns the last nanosecond of the quarter of t in timezone tz
+func EndOfQuarter(t int64, tz string) (int64, error) {
+	return endOf(t, tz, startOfQuarter, nextQuarter)
+}
+
+// StartOfYear returns the midnight starting the year of t in timezone tz
+func StartOfYear(t int64, tz string) (int64, error) { return startOf(t, tz, startOfYear) }
+
+// EndOfYear returns the last nanosecond of the year of t in timezone tz
+func EndOfYear(t int64, tz string) (int64, error) { return endOf(t, tz, startOfYear, nextYear) }
+
+// AddMonths adds n (could be negative) calendar months to t in timezone tz,
+// keeping the wall clock. Days missing from the resulting month are clamped
+// to its last day, e.g: Jan 31 + 1 month is Feb 29 on leap years rather than
+// Mar 2 like time.AddDate.
+func AddMonths(t int64, n int, tz string) (int64, error) {
+	tt, err := inTimezone(t, tz)
+	if err != nil {
+		return 0, err
+	}
+	return addMonths(tt, n).UnixNano(), nil
+}
+
+func addMonths(t time.Time, n int) time.Time {
+	year, month, day := t.Date()
+	// day 0 of the month after the target month is the last day of the
+	// target month
+	last := time.Date(year, month+time.Month(n)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if day > last {
+		day = last
+	}
+	hour, min, sec := t.Clock()
+	return time.Date(year, month+time.Month(n), day, hour, min, sec, t.Nanosecond(), t.Location())
+}
+
+// MonthsBetween returns the number of whole calendar months from a to b in
+// timezone tz, negative when b is before a. A month has elapsed when
+// AddMonths(a, 1) is reached, so Jan 31 to Feb 29 is 1 month and Jan 15 to
+// Feb 14 is 0.
+func MonthsBetween(a, b int64, tz string) (int, error) {
+	ta, err := inTimezone(a, tz)
+	if err != nil {
+		return 0, err
+	}
+	return monthsBetween(ta, Guess(b).Time().In(ta.Location())), nil
+}
+
+// monthsBetween returns the number of whole calendar months from ta to tb in
+// the location of ta, see MonthsBetween
+func monthsBetween(ta, tb time.Time) int {
+	n := (tb.Year()-ta.Year())*12 + int(tb.Month()-ta.Month())
+	if n > 0 && addMonths(ta, n).After(tb) {
+		n--
+	}
+	if n < 0 && addMonths(ta, n).Before(tb) {
+		n++
+	}
+	return n
+}
+
+// YearsBetween returns the number of whole calendar years from a to b in
+// timezone tz, negative when b is before a, see MonthsBetween
+func YearsBetween(a, b int64, tz string) (int, error) {
+	months, err := MonthsBetween(a, b, tz)
+	return months / 12, err
+}
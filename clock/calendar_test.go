@@ -0,0 +1,132 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarBoundaries(t *testing.T) {
+	at := func(s string) int64 {
+		tim, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tim.UnixNano()
+	}
+	monday := func(t int64, tz string) (int64, error) { return StartOfWeek(t, tz, time.Monday) }
+	endMonday := func(t int64, tz string) (int64, error) { return EndOfWeek(t, tz, time.Monday) }
+	sunday := func(t int64, tz string) (int64, error) { return StartOfWeek(t, tz, time.Sunday) }
+
+	// 2024-03-10 02:00 is skipped in New York
+	dst := at("2024-03-10T15:00:00Z")
+	tcs := []struct {
+		name string
+		f    func(int64, string) (int64, error)
+		t    int64
+		tz   string
+		want string
+	}{
+		{"StartOfDay", StartOfDay, at("2024-01-15T18:00:00Z"), "+07:00", "2024-01-15T17:00:00Z"},
+		{"StartOfDay sec", StartOfDay, at("2024-01-15T18:00:00Z") / 1e9, "+07:00", "2024-01-15T17:00:00Z"},
+		{"StartOfDay ms", StartOfDay, at("2024-01-15T18:00:00Z") / 1e6, "", "2024-01-15T00:00:00Z"},
+		{"EndOfDay", EndOfDay, at("2024-01-15T18:00:00Z"), "+07:00", "2024-01-16T16:59:59.999999999Z"},
+		{"StartOfDay dst", StartOfDay, dst, "America/New_York", "2024-03-10T05:00:00Z"},
+		{"EndOfDay dst", EndOfDay, dst, "America/New_York", "2024-03-11T03:59:59.999999999Z"},
+		{"StartOfWeek monday", monday, at("2024-03-10T15:00:00Z"), "", "2024-03-04T00:00:00Z"},
+		{"StartOfWeek monday on monday", monday, at("2024-03-11T00:00:00Z"), "", "2024-03-11T00:00:00Z"},
+		{"StartOfWeek sunday", sunday, at("2024-03-10T15:00:00Z"), "", "2024-03-10T00:00:00Z"},
+		{"StartOfWeek tz", monday, at("2024-03-10T18:00:00Z"), "+07:00", "2024-03-10T17:00:00Z"},
+		{"EndOfWeek dst", endMonday, dst, "America/New_York", "2024-03-11T03:59:59.999999999Z"},
+		{"StartOfMonth", StartOfMonth, at("2024-02-29T23:00:00Z"), "", "2024-02-01T00:00:00Z"},
+		{"StartOfMonth tz", StartOfMonth, at("2024-02-29T23:00:00Z"), "+07:00", "2024-02-29T17:00:00Z"},
+		{"EndOfMonth leap", EndOfMonth, at("2024-02-10T00:00:00Z"), "", "2024-02-29T23:59:59.999999999Z"},
+		{"EndOfMonth december", EndOfMonth, at("2023-12-10T00:00:00Z"), "", "2023-12-31T23:59:59.999999999Z"},
+		{"StartOfQuarter", StartOfQuarter, at("2024-06-30T12:00:00Z"), "", "2024-04-01T00:00:00Z"},
+		{"EndOfQuarter", EndOfQuarter, at("2024-11-30T12:00:00Z"), "", "2024-12-31T23:59:59.999999999Z"},
+		{"StartOfYear", StartOfYear, at("2024-06-30T12:00:00Z"), "America/New_York", "2024-01-01T05:00:00Z"},
+		{"EndOfYear", EndOfYear, at("2024-06-30T12:00:00Z"), "", "2024-12-31T23:59:59.999999999Z"},
+	}
+	for _, tc := range tcs {
+		out, err := tc.f(tc.t, tc.tz)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := time.Unix(0, out).UTC().Format(time.RFC3339Nano); got != tc.want {
+			t.Errorf("%s: should be %s got %s", tc.name, tc.want, got)
+		}
+	}
+
+	if _, err := StartOfMonth(0, "Nowhere/City"); err == nil {
+		t.Errorf("should fail on unknown timezone")
+	}
+}
+
+func TestAddMonths(t *testing.T) {
+	tcs := []struct {
+		t    string
+		n    int
+		tz   string
+		want string
+	}{
+		{"2024-01-31T10:00:00Z", 1, "", "2024-02-29T10:00:00Z"},
+		{"2023-01-31T10:00:00Z", 1, "", "2023-02-28T10:00:00Z"},
+		{"2024-03-31T10:00:00Z", -1, "", "2024-02-29T10:00:00Z"},
+		{"2024-05-31T10:00:00Z", 1, "", "2024-06-30T10:00:00Z"},
+		{"2024-01-15T10:00:00Z", 12, "", "2025-01-15T10:00:00Z"},
+		{"2024-11-30T10:00:00Z", 3, "", "2025-02-28T10:00:00Z"},
+		{"2024-01-15T10:00:00Z", -13, "", "2022-12-15T10:00:00Z"},
+		// Jan 31 in Ho Chi Minh city
+		{"2024-01-30T20:00:00Z", 1, "+07:00", "2024-02-28T20:00:00Z"},
+		// wall clock is kept across daylight saving time
+		{"2024-02-15T14:00:00Z", 1, "America/New_York", "2024-03-15T13:00:00Z"},
+	}
+	for _, tc := range tcs {
+		tim, _ := time.Parse(time.RFC3339, tc.t)
+		// milliseconds
+		out, err := AddMonths(tim.UnixMilli(), tc.n, tc.tz)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.t, err)
+		}
+		if got := time.Unix(0, out).UTC().Format(time.RFC3339); got != tc.want {
+			t.Errorf("%s + %d: should be %s got %s", tc.t, tc.n, tc.want, got)
+		}
+	}
+}
+
+func TestMonthsBetween(t *testing.T) {
+	tcs := []struct {
+		a, b   string
+		tz     string
+		months int
+		years  int
+	}{
+		{"2024-01-31T10:00:00Z", "2024-02-29T10:00:00Z", "", 1, 0},
+		{"2024-01-31T10:00:00Z", "2024-02-29T09:59:59Z", "", 0, 0},
+		{"2024-01-15T00:00:00Z", "2024-02-14T00:00:00Z", "", 0, 0},
+		{"2024-01-15T00:00:00Z", "2024-02-15T00:00:00Z", "", 1, 0},
+		{"2024-02-15T00:00:00Z", "2024-01-15T00:00:00Z", "", -1, 0},
+		{"2024-02-15T00:00:00Z", "2024-01-16T00:00:00Z", "", 0, 0},
+		{"2022-03-01T00:00:00Z", "2024-02-29T00:00:00Z", "", 23, 1},
+		{"2022-03-01T00:00:00Z", "2024-03-01T00:00:00Z", "", 24, 2},
+		{"2024-03-01T00:00:00Z", "2022-03-01T00:00:00Z", "", -24, -2},
+		// Jan 31 to Feb 29 in Ho Chi Minh city, Jan 30 to Feb 28 in UTC
+		{"2024-01-30T20:00:00Z", "2024-02-28T20:00:00Z", "+07:00", 1, 0},
+		{"2024-01-30T20:00:00Z", "2024-02-28T19:59:59Z", "+07:00", 0, 0},
+		{"2024-01-30T20:00:00Z", "2024-02-28T20:00:00Z", "", 0, 0},
+	}
+	for _, tc := range tcs {
+		a, _ := time.Parse(time.RFC3339, tc.a)
+		b, _ := time.Parse(time.RFC3339, tc.b)
+		// mixed units
+		months, err := MonthsBetween(a.Unix(), b.UnixNano(), tc.tz)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.a, err)
+		}
+		years, _ := YearsBetween(a.Unix(), b.UnixNano(), tc.tz)
+		if months != tc.months || years != tc.years {
+			t.Errorf("%s to %s %s: should be %d months %d years, got %d %d", tc.a, tc.b, tc.tz,
+				tc.months, tc.years, months, years)
+		}
+	}
+}
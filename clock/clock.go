@@ -15,11 +15,13 @@ func GetHourOfDay(t int64) int {
 // UnixSec returns number of years that have elapsed since 00:00:00 1/1/1970 UTC
 // to current time t.
 // Parameter t could be nanosecond, millisecond, microsecond or second.
+// Years are approximated by 365 days, see YearsBetween for calendar years.
 func UnixYear(t int64) int64 { return UnixNano(t) / 24 / int64(time.Hour) / 365 }
 
 // UnixSec returns number of months that have elapsed since 00:00:00 1/1/1970 UTC
 // to current time t.
 // Parameter t could be nanosecond, millisecond, microsecond or second.
+// Months are approximated by 30 days, see MonthsBetween for calendar months.
 func UnixMonth(t int64) int64 { return UnixNano(t) / 24 / int64(time.Hour) / 30 }
 
 // UnixSec returns number of days that have elapsed since 00:00:00 1/1/1970 UTC
@@ -35,6 +37,9 @@ func UnixMin(t int64) int64 { return UnixNano(t) / int64(time.Minute) }
 // UnixSec returns number of seconds that have elapsed since 00:00:00 1/1/1970 UTC
 // to current time t.
 // Parameter t could be nanosecond, millisecond, microsecond or second.
+//
+// Deprecated: the unit of t is guessed silently, use Guess(t).Time().Unix()
+// or FromMilli, FromNano... when the unit is known.
 func UnixSec(t int64) int64 { return UnixNano(t) / int64(time.Second) }
 
 // UnixHour returns number of hours that have elapsed since 00:00:00 1/1/1970 UTC
@@ -50,43 +55,89 @@ func IsNano(t int64) bool {
 // Midnight returns number of nano seconds elapsed since 0h0m0s 1/1/1970 UTC to
 // the midnight of the current day in timezone tzoffset
 // E.g: Midnight("+07:00"), Midnight("00:00")
+// See MidnightIn to use a Clock, e.g: MidnightIn(c.Now(), tz)
 func Midnight(tzoffset string) int64 {
 	h, _, _ := SplitTzOffset(tzoffset)
-	year, month, day := time.Now().UTC().Date()
+	year, month, day := Real.Now().UTC().Date()
 	curmidnight := time.Date(year, month, day, 23, 59, 59, 0, time.UTC)
 	curmidnight_inzone := curmidnight.Add(-time.Duration(h) * time.Hour)
 	return curmidnight_inzone.UnixNano()
 }
 
+// MidnightIn returns number of nano seconds elapsed since 0h0m0s 1/1/1970 UTC
+// to the midnight ending the day of t in timezone tz, a name (e.g:
+// "America/New_York") or an offset. Like Midnight, it is 23:59:59 of the
+// day, see StartOfDay for the midnight starting it.
+func MidnightIn(t time.Time, tz string) (int64, error) {
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return 0, err
+	}
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 23, 59, 59, 0, loc).UnixNano(), nil
+}
+
+// OneMonth is the longest month, see AddMonths for calendar months
 const OneMonth = 31 * 24 * time.Hour
 
-// ToMili converts t (nanosecond, millisecond, microsecond or second) into
+// UnixMili converts t (nanosecond, millisecond, microsecond or second) into
 // millisecond integer
+//
+// Deprecated: the unit of t is guessed silently, use Guess(t).In(Milli).Int64()
+// or FromSec, FromNano... when the unit is known.
 func UnixMili(t int64) int64 { return UnixNano(t) / 1e+6 }
 
 func RoundSecNano(t int64) int64 { return UnixSec(t) * int64(time.Second) }
 
 // UnixNano convert t (nanosecond, millisecond, microsecond or second) into
-// nanosecond integer
-func UnixNano(t int64) int64 {
-	if t > 1e+18 { // nanoseconds
-		return t
+// nanosecond integer, the unit is guessed from the magnitude of t, see Guess
+// for the ranges of each unit
+//
+// Deprecated: the unit of t is guessed silently, use Guess(t).UnixNano() or
+// FromSec, FromMilli... when the unit is known.
+func UnixNano(t int64) int64 { return t * int64(guessUnit(t)) }
+
+// locMap maps timezone names and offsets to *time.Location, used internally
+// in LoadLocation, since call to function time.LoadLocation take very long
+// time, this variable is used to cache function responses. Locations are
+// cached rather than offsets since the offset of a timezone changes with
+// daylight saving time.
+var locMap = &sync.Map{}
+
+// LoadLocation returns the location of timezone tz, which could be a name
+// taken in the IANA Time Zone database (e.g: "America/New_York") or a fixed
+// offset (e.g: "+07:00"). Empty tz is UTC.
+// This function use locMap global variable as cache
+// CAUTION: in order to load names, OS must have tzdata package (use 'apk add
+// tzdata' to install) or the program must import time/tzdata
+func LoadLocation(tz string) (*time.Location, error) {
+	if tz == "" || tz == "UTC" {
+		return time.UTC, nil
 	}
-	if t > 1e+15 { // microseconds
-		return t * 1e+3
+	if loc, ok := locMap.Load(tz); ok {
+		return loc.(*time.Location), nil
 	}
-	if t > 1e+12 { // milliseconds
-		return t * 1e+6
+
+	loc, err := fixedZone(tz)
+	if err != nil {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return nil, err
+		}
 	}
-	return t * 1e+9 // seconds
+	locMap.Store(tz, loc)
+	return loc, nil
 }
 
-// tzMap map timezone name to UTC timezone, used internally in TimezoneToUTC,
-// since call to function time.LoadLocation take very long time, this variable
-// is used to cache function responses
-var tzMap = &sync.Map{}
+// fixedZone returns the location of a timezone offset, e.g: +07:00
+func fixedZone(offset string) (*time.Location, error) {
+	h, m, err := SplitTzOffset(offset)
+	if err != nil {
+		return nil, err
+	}
+	return time.FixedZone(offset, h*3600+m*60), nil
+}
 
-// TimezoneToUTC convert timezone name to UTC timezone
+// TimezoneToUTC convert timezone name to UTC timezone at the current time
 // The name should be taken in the IANA Time Zone database, for examples:
 //
 //	"America/New_York", "Asia/Ho_Chi_Minh"
@@ -95,29 +146,31 @@ var tzMap = &sync.Map{}
 //
 //	TimezoneToUTC("Asia/Ho_Chi_Minh") -> +07:00
 //
-// This function use tzMap global variable as cache
-// CAUTION: in order to run, OS must have tzdata package (use 'apk add tzdata'
-// to install)
+// Unknown names are +00:00. See TimezoneToUTCAt to use a Clock, e.g:
+// TimezoneToUTCAt(tz, c.Now())
 func TimezoneToUTC(tzName string) string {
-	// predefined cache value, for extreme fast lookup
+	// predefined value, for extreme fast lookup
 	switch tzName {
 	case "":
 		return "+00:00"
 	case "Asia/Ho_Chi_Minh":
 		return "+07:00"
 	}
+	return TimezoneToUTCAt(tzName, Real.Now())
+}
 
-	// look up in cache
-	if tz, ok := tzMap.Load(tzName); ok {
-		return tz.(string)
-	}
-
-	// cache miss, look up in database
-	utc, err := time.LoadLocation(tzName)
+// TimezoneToUTCAt convert timezone name to UTC timezone at time t, the
+// offset of timezones observing daylight saving time depends on t
+// examples:
+//
+//	TimezoneToUTCAt("America/New_York", <2024-01-01>) -> -05:00
+//	TimezoneToUTCAt("America/New_York", <2024-07-01>) -> -04:00
+func TimezoneToUTCAt(tzName string, t time.Time) string {
+	loc, err := LoadLocation(tzName)
 	if err != nil {
 		return "+00:00"
 	}
-	_, z := time.Now().In(utc).Zone()
+	_, z := t.In(loc).Zone()
 	sign := "+"
 	if z < 0 {
 		sign = "-"
@@ -125,7 +178,7 @@ func TimezoneToUTC(tzName string) string {
 	}
 
 	h := z / 3600
-	m := z % 3600
+	m := z % 3600 / 60
 	hh := strconv.Itoa(h)
 	mm := strconv.Itoa(m)
 	if len(hh) < 2 {
@@ -135,9 +188,7 @@ func TimezoneToUTC(tzName string) string {
 	if len(mm) < 2 {
 		mm = "0" + mm
 	}
-	tz := sign + hh + ":" + mm
-	tzMap.Store(tzName, tz)
-	return tz
+	return sign + hh + ":" + mm
 }
 
 // tz: 07:00
@@ -153,6 +204,19 @@ func ConvertTimezone(t time.Time, tz string) (year, mon, day, hour, min int, wee
 		t.Weekday().String(), nil
 }
 
+// ConvertTimezoneIn is ConvertTimezone for timezone names (e.g:
+// "America/New_York") or offsets, the offset is resolved at time t
+func ConvertTimezoneIn(t time.Time, tz string) (year, mon, day, hour, min int, weekday string, err error) {
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return 0, 0, 0, 0, 0, "", err
+	}
+
+	t = t.In(loc)
+	return t.Year(), int(t.Month()), t.Day(), t.Hour(), t.Minute(),
+		t.Weekday().String(), nil
+}
+
 // SplitTzOffset splits timezone offset (e.g: +07:00) to hour and minute
 // pair (e.g: 7, 0)
 // timezone offset must follow +hh:mm or -hh:mm, otherwise the function
@@ -224,4 +288,21 @@ func SubDays(a, b int64, tz string) int {
 	return (tzb - tza) / 86400
 }
 
+// SubDaysIn returns the number of calendar days from a to b in timezone tz, a
+// name (e.g: "America/New_York") or an offset. Days are counted by date so
+// days of 23 or 25 hours count as one.
+// Parameters a and b could be nanosecond, millisecond, microsecond or second.
+func SubDaysIn(a, b int64, tz string) (int, error) {
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return 0, err
+	}
+	ay, am, ad := Guess(a).Time().In(loc).Date()
+	by, bm, bd := Guess(b).Time().In(loc).Date()
+	// dates at noon UTC are 24 hours apart
+	adate := time.Date(ay, am, ad, 12, 0, 0, 0, time.UTC)
+	bdate := time.Date(by, bm, bd, 12, 0, 0, 0, time.UTC)
+	return int(bdate.Sub(adate) / (24 * time.Hour)), nil
+}
+
 func isNumeric(r byte) bool { return '0' <= r && r <= '9' }
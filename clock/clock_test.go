@@ -88,3 +88,124 @@ func TestConvertTimezone(t *testing.T) {
 		}
 	}
 }
+
+func TestTimezoneToUTCAt(t *testing.T) {
+	tcs := []struct {
+		tz     string
+		at     string
+		offset string
+	}{
+		{"America/New_York", "2024-01-15T12:00:00Z", "-05:00"},
+		{"America/New_York", "2024-07-15T12:00:00Z", "-04:00"},
+		{"America/New_York", "2024-03-10T06:59:59Z", "-05:00"},
+		{"America/New_York", "2024-03-10T07:00:00Z", "-04:00"},
+		{"Asia/Kolkata", "2024-01-15T12:00:00Z", "+05:30"},
+		{"Asia/Ho_Chi_Minh", "2024-01-15T12:00:00Z", "+07:00"},
+		{"+07:00", "2024-01-15T12:00:00Z", "+07:00"},
+		{"", "2024-01-15T12:00:00Z", "+00:00"},
+		{"Mars/Olympus_Mons", "2024-01-15T12:00:00Z", "+00:00"},
+	}
+
+	for _, tc := range tcs {
+		at, _ := time.Parse(time.RFC3339, tc.at)
+		// twice, the second lookup hits the cache
+		for i := 0; i < 2; i++ {
+			if offset := TimezoneToUTCAt(tc.tz, at); offset != tc.offset {
+				t.Errorf("%s at %s: should be %s got %s", tc.tz, tc.at, tc.offset, offset)
+			}
+		}
+	}
+
+	if _, err := LoadLocation("Mars/Olympus_Mons"); err == nil {
+		t.Errorf("should fail to load unknown timezone")
+	}
+	if _, err := LoadLocation("+25:00"); err == nil {
+		t.Errorf("should fail to load invalid offset")
+	}
+}
+
+func TestConvertTimezoneIn(t *testing.T) {
+	tcs := []struct {
+		intime  string
+		tz      string
+		day     int
+		hour    int
+		min     int
+		weekday string
+	}{
+		{"2024-01-15T15:04:00Z", "America/New_York", 15, 10, 4, "Monday"},
+		{"2024-07-15T15:04:00Z", "America/New_York", 15, 11, 4, "Monday"},
+		{"2024-07-15T20:04:00Z", "Asia/Ho_Chi_Minh", 16, 3, 4, "Tuesday"},
+		{"2024-07-15T20:04:00Z", "+05:30", 16, 1, 34, "Tuesday"},
+	}
+
+	for _, tc := range tcs {
+		tim, _ := time.Parse(time.RFC3339, tc.intime)
+		_, _, day, hour, min, weekday, err := ConvertTimezoneIn(tim, tc.tz)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.intime, err)
+		}
+		if day != tc.day || hour != tc.hour || min != tc.min || weekday != tc.weekday {
+			t.Errorf("%s %s: should be %d %d:%d %s, got %d %d:%d %s", tc.intime, tc.tz,
+				tc.day, tc.hour, tc.min, tc.weekday, day, hour, min, weekday)
+		}
+	}
+
+	if _, _, _, _, _, _, err := ConvertTimezoneIn(time.Now(), "Nowhere/City"); err == nil {
+		t.Errorf("should fail on unknown timezone")
+	}
+}
+
+func TestMidnightIn(t *testing.T) {
+	tcs := []struct {
+		intime   string
+		tz       string
+		midnight string
+	}{
+		{"2024-01-15T03:00:00Z", "America/New_York", "2024-01-15T04:59:59Z"},
+		{"2024-07-15T03:00:00Z", "America/New_York", "2024-07-15T03:59:59Z"},
+		// the day daylight saving time starts, midnight is -04:00
+		{"2024-03-10T20:00:00Z", "America/New_York", "2024-03-11T03:59:59Z"},
+		{"2024-01-15T18:00:00Z", "+07:00", "2024-01-16T16:59:59Z"},
+	}
+
+	for _, tc := range tcs {
+		tim, _ := time.Parse(time.RFC3339, tc.intime)
+		midnight, err := MidnightIn(tim, tc.tz)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.intime, err)
+		}
+		if got := time.Unix(0, midnight).UTC().Format(time.RFC3339); got != tc.midnight {
+			t.Errorf("%s %s: should be %s got %s", tc.intime, tc.tz, tc.midnight, got)
+		}
+	}
+}
+
+func TestSubDaysIn(t *testing.T) {
+	tcs := []struct {
+		a   string
+		b   string
+		tz  string
+		day int
+	}{
+		{"2019-10-12T16:20:50Z", "2019-10-12T18:20:50Z", "+07:00", 1},
+		{"2019-10-12T18:20:50Z", "2019-10-12T16:20:50Z", "+07:00", -1},
+		{"2019-10-12T16:20:50Z", "2019-10-12T18:20:50Z", "Asia/Ho_Chi_Minh", 1},
+		// 2024-03-10 has 23 hours in New York
+		{"2024-03-10T04:30:00Z", "2024-03-11T03:30:00Z", "America/New_York", 1},
+		{"2024-03-09T05:00:00Z", "2024-03-12T03:59:00Z", "America/New_York", 2},
+		{"2024-03-01T00:00:00Z", "2024-04-01T00:00:00Z", "", 31},
+	}
+
+	for i, tc := range tcs {
+		a, _ := time.Parse(time.RFC3339, tc.a)
+		b, _ := time.Parse(time.RFC3339, tc.b)
+		day, err := SubDaysIn(a.UnixMilli(), b.UnixMilli(), tc.tz)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if tc.day != day {
+			t.Errorf("%d: should be %d got %d", i, tc.day, day)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package clock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Locale holds the words used to print durations and relative times. Names
+// of units are listed from the largest: years, months, weeks, days, hours,
+// minutes and seconds.
+type Locale struct {
+	Short    [7]string // suffixes of compact durations, e.g: "h"
+	Singular [7]string // e.g: "hour"
+	Plural   [7]string // e.g: "hours", same as Singular in languages without plural
+
+	Ago string // format of past times, e.g: "%s ago"
+	In  string // format of future times, e.g: "in %s"
+	Now string // times less than a minute away, e.g: "just now"
+}
+
+// English is the default locale
+var English = &Locale{
+	Short:    [7]string{"y", "mo", "w", "d", "h", "m", "s"},
+	Singular: [7]string{"year", "month", "week", "day", "hour", "minute", "second"},
+	Plural:   [7]string{"years", "months", "weeks", "days", "hours", "minutes", "seconds"},
+	Ago:      "%s ago",
+	In:       "in %s",
+	Now:      "just now",
+}
+
+// Vietnamese is the locale of language tag vi, compact durations use the
+// international suffixes
+var Vietnamese = &Locale{
+	Short:    [7]string{"y", "mo", "w", "d", "h", "m", "s"},
+	Singular: [7]string{"năm", "tháng", "tuần", "ngày", "giờ", "phút", "giây"},
+	Plural:   [7]string{"năm", "tháng", "tuần", "ngày", "giờ", "phút", "giây"},
+	Ago:      "%s trước",
+	In:       "%s nữa",
+	Now:      "vừa xong",
+}
+
+// spans are the lengths of units of Locale, months and years have no fixed
+// length, see Relative
+var spans = [7]time.Duration{
+	0,
+	0,
+	7 * 24 * time.Hour,
+	24 * time.Hour,
+	time.Hour,
+	time.Minute,
+	time.Second,
+}
+
+// durationDay is the first unit of durations, months and years have no
+// fixed length
+const durationDay = 3
+
+// localeMap holds registered locales by language tag, e.g: "vi"
+var localeMap = &sync.Map{}
+
+func init() {
+	localeMap.Store("en", English)
+	localeMap.Store("vi", Vietnamese)
+}
+
+// RegisterLocale makes locale l available to LookupLocale under language tag
+// lang, e.g: "fr"
+func RegisterLocale(lang string, l *Locale) error {
+	if lang == "" || l == nil {
+		return fmt.Errorf("clock: invalid locale %q", lang)
+	}
+	if _, loaded := localeMap.LoadOrStore(strings.ToLower(lang), l); loaded {
+		return fmt.Errorf("clock: locale %s is already registered", lang)
+	}
+	return nil
+}
+
+// LookupLocale returns the locale of language tag lang, e.g: "vi" or
+// "vi-VN", falling back to English
+func LookupLocale(lang string) *Locale {
+	lang = strings.ToLower(lang)
+	if l, ok := localeMap.Load(lang); ok {
+		return l.(*Locale)
+	}
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		if l, ok := localeMap.Load(lang[:i]); ok {
+			return l.(*Locale)
+		}
+	}
+	return English
+}
+
+func (l *Locale) or() *Locale {
+	if l == nil {
+		return English
+	}
+	return l
+}
+
+// long returns n units in words, e.g: "3 minutes"
+func (l *Locale) long(n int64, unit int) string {
+	name := l.Plural[unit]
+	if n == 1 {
+		name = l.Singular[unit]
+	}
+	return strconv.FormatInt(n, 10) + " " + name
+}
+
+// ShortDuration formats d in days, hours, minutes and seconds, truncating
+// smaller units, e.g: "1h 20m", "2d 3h", "0s"
+func ShortDuration(d time.Duration, l *Locale) string {
+	l = l.or()
+	return formatDuration(d, "0"+l.Short[durationDay+3], func(n int64, unit int) string {
+		return strconv.FormatInt(n, 10) + l.Short[unit]
+	})
+}
+
+// LongDuration formats d in words, e.g: "1 hour 20 minutes"
+func LongDuration(d time.Duration, l *Locale) string {
+	l = l.or()
+	return formatDuration(d, l.long(0, durationDay+3), l.long)
+}
+
+func formatDuration(d time.Duration, zero string, part func(n int64, unit int) string) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+	}
+	var parts []string
+	for unit := durationDay; unit < len(spans); unit++ {
+		n := int64(d / spans[unit])
+		d -= time.Duration(n) * spans[unit]
+		if n < 0 {
+			n = -n
+		}
+		if n > 0 {
+			parts = append(parts, part(n, unit))
+		}
+	}
+	if len(parts) == 0 {
+		return zero
+	}
+	return sign + strings.Join(parts, " ")
+}
+
+// Relative formats t relative to ref in the largest whole unit, e.g: "3
+// minutes ago", "in 2 hours" or "just now" when they are less than a minute
+// apart. Months and years are calendar ones in the location of ref, see
+// MonthsBetween, e.g: Feb 10 is 1 month after Jan 10.
+func Relative(t, ref time.Time, l *Locale) string {
+	l = l.or()
+	d := t.Sub(ref)
+	format := l.In
+	from, to := ref, t.In(ref.Location())
+	if d < 0 {
+		d, format = -d, l.Ago
+		from, to = to, from
+	}
+	if d < time.Minute {
+		return l.Now
+	}
+	months := monthsBetween(from, to)
+	if months >= 12 {
+		return fmt.Sprintf(format, l.long(int64(months/12), 0))
+	}
+	if months > 0 {
+		return fmt.Sprintf(format, l.long(int64(months), 1))
+	}
+	// weeks and smaller units have a fixed length
+	for unit := durationDay - 1; unit < len(spans); unit++ {
+		if d >= spans[unit] {
+			return fmt.Sprintf(format, l.long(int64(d/spans[unit]), unit))
+		}
+	}
+	return l.Now
+}
+
+// durationUnits maps the units accepted by ParseDuration to their length
+var durationUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond, "µs": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// ParseDuration parses human durations: a sequence of numbers, which may be
+// decimal, each followed by a unit and optionally separated by spaces, e.g:
+// "1d2h", "90m", "1.5h", "-2w", "1h 20m", "3 days". Units are w, d, h, m, s,
+// ms, us and ns or their English names. Days are 24 hours.
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = strings.TrimSpace(s[1:])
+	}
+	if s == "" {
+		return 0, fmt.Errorf("clock: invalid duration %q", orig)
+	}
+
+	var total int64
+	for s != "" {
+		i := 0
+		for i < len(s) && (s[i] == '.' || isNumeric(s[i])) {
+			i++
+		}
+		num := s[:i]
+		s = strings.TrimLeft(s[i:], " ")
+		j := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsLetter(r) })
+		if j < 0 {
+			j = len(s)
+		}
+		unit, ok := durationUnits[strings.ToLower(s[:j])]
+		if !ok {
+			return 0, fmt.Errorf("clock: unknown unit %q in duration %q", s[:j], orig)
+		}
+		s = strings.TrimLeft(s[j:], " ,")
+
+		// whole part then fraction, e.g: 1.5h is 1h + 0.5h
+		whole, frac, _ := strings.Cut(num, ".")
+		if whole == "" && frac == "" {
+			return 0, fmt.Errorf("clock: invalid duration %q", orig)
+		}
+		var n int64
+		if whole != "" {
+			var err error
+			if n, err = strconv.ParseInt(whole, 10, 64); err != nil || n > (1<<63-1)/int64(unit) {
+				return 0, fmt.Errorf("clock: duration %q is out of range", orig)
+			}
+		}
+		n *= int64(unit)
+		if frac != "" {
+			f, err := strconv.ParseFloat("0."+frac, 64)
+			if err != nil {
+				return 0, fmt.Errorf("clock: invalid duration %q", orig)
+			}
+			n += int64(f * float64(unit))
+		}
+		if total > 1<<63-1-n {
+			return 0, fmt.Errorf("clock: duration %q is out of range", orig)
+		}
+		total += n
+	}
+	if neg {
+		total = -total
+	}
+	return time.Duration(total), nil
+}
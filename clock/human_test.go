@@ -0,0 +1,123 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	tcs := []struct {
+		d           time.Duration
+		short, long string
+		vi          string
+	}{
+		{0, "0s", "0 seconds", "0 giây"},
+		{999 * time.Millisecond, "0s", "0 seconds", "0 giây"},
+		{time.Second, "1s", "1 second", "1 giây"},
+		{80 * time.Minute, "1h 20m", "1 hour 20 minutes", "1 giờ 20 phút"},
+		{26*time.Hour + 5*time.Second, "1d 2h 5s", "1 day 2 hours 5 seconds", "1 ngày 2 giờ 5 giây"},
+		{-90 * time.Second, "-1m 30s", "-1 minute 30 seconds", "-1 phút 30 giây"},
+		{400 * 24 * time.Hour, "400d", "400 days", "400 ngày"},
+	}
+	for _, tc := range tcs {
+		if out := ShortDuration(tc.d, nil); out != tc.short {
+			t.Errorf("%v: should be %s, got %s", tc.d, tc.short, out)
+		}
+		if out := LongDuration(tc.d, English); out != tc.long {
+			t.Errorf("%v: should be %s, got %s", tc.d, tc.long, out)
+		}
+		if out := LongDuration(tc.d, LookupLocale("vi-VN")); out != tc.vi {
+			t.Errorf("%v: should be %s, got %s", tc.d, tc.vi, out)
+		}
+		// short durations parse back
+		if d, err := ParseDuration(ShortDuration(tc.d, nil)); err != nil || d != tc.d.Truncate(time.Second) {
+			t.Errorf("%v: should parse back, got %v %v", tc.d, d, err)
+		}
+	}
+}
+
+func TestRelative(t *testing.T) {
+	ref := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	tcs := []struct {
+		d      time.Duration
+		en, vi string
+	}{
+		{0, "just now", "vừa xong"},
+		{-59 * time.Second, "just now", "vừa xong"},
+		{-time.Minute, "1 minute ago", "1 phút trước"},
+		{-3*time.Minute - 59*time.Second, "3 minutes ago", "3 phút trước"},
+		{2 * time.Hour, "in 2 hours", "2 giờ nữa"},
+		{-36 * time.Hour, "1 day ago", "1 ngày trước"},
+		{15 * 24 * time.Hour, "in 2 weeks", "2 tuần nữa"},
+		{-61 * 24 * time.Hour, "2 months ago", "2 tháng trước"},
+		{800 * 24 * time.Hour, "in 2 years", "2 năm nữa"},
+		// calendar months and years
+		{-29 * 24 * time.Hour, "1 month ago", "1 tháng trước"},
+		{-28 * 24 * time.Hour, "4 weeks ago", "4 tuần trước"},
+		{-365 * 24 * time.Hour, "11 months ago", "11 tháng trước"},
+		{365 * 24 * time.Hour, "in 1 year", "1 năm nữa"},
+	}
+	for _, tc := range tcs {
+		if out := Relative(ref.Add(tc.d), ref, nil); out != tc.en {
+			t.Errorf("%v: should be %s, got %s", tc.d, tc.en, out)
+		}
+		if out := Relative(ref.Add(tc.d), ref, Vietnamese); out != tc.vi {
+			t.Errorf("%v: should be %s, got %s", tc.d, tc.vi, out)
+		}
+	}
+
+	fr := &Locale{
+		Singular: [7]string{"an", "mois", "semaine", "jour", "heure", "minute", "seconde"},
+		Plural:   [7]string{"ans", "mois", "semaines", "jours", "heures", "minutes", "secondes"},
+		Ago:      "il y a %s",
+		In:       "dans %s",
+		Now:      "à l'instant",
+	}
+	if err := RegisterLocale("fr", fr); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterLocale("fr", fr); err == nil {
+		t.Errorf("should not register fr twice")
+	}
+	if out := Relative(ref.Add(-3*time.Hour), ref, LookupLocale("FR")); out != "il y a 3 heures" {
+		t.Errorf("should use registered locale, got %s", out)
+	}
+	if LookupLocale("de") != English {
+		t.Errorf("should fall back to English")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tcs := []struct {
+		s string
+		d time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"1d2h", 26 * time.Hour},
+		{"1h 20m", 80 * time.Minute},
+		{"1.5h", 90 * time.Minute},
+		{".5d", 12 * time.Hour},
+		{"-2w", -14 * 24 * time.Hour},
+		{" + 3 days, 4 hours ", 76 * time.Hour},
+		{"1H30S", time.Hour + 30*time.Second},
+		{"250ms", 250 * time.Millisecond},
+		{"1µs2ns", 1002 * time.Nanosecond},
+		{"2562047h", 2562047 * time.Hour},
+	}
+	for _, tc := range tcs {
+		d, err := ParseDuration(tc.s)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.s, err)
+			continue
+		}
+		if d != tc.d {
+			t.Errorf("%q: should be %v, got %v", tc.s, tc.d, d)
+		}
+	}
+
+	for _, invalid := range []string{"", "-", "90", "h", "1x", "1.2.3h", "1h-2m", "3 fortnights", "2562048h", "106751d 1d"} {
+		if d, err := ParseDuration(invalid); err == nil {
+			t.Errorf("%q: should fail, got %v", invalid, d)
+		}
+	}
+}
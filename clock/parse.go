@@ -0,0 +1,163 @@
+package clock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateOrder tells how to read numeric dates such as 03/04/2018
+type DateOrder int
+
+const (
+	DMY DateOrder = iota // 03/04/2018 is 3 April 2018, used by most countries
+	MDY                  // 03/04/2018 is March 4 2018, used in the US
+)
+
+// DateOrderOf returns the date order of language tag lang, e.g: MDY for
+// en-US, DMY for vi-VN or en-GB
+func DateOrderOf(lang string) DateOrder {
+	switch strings.ToLower(strings.ReplaceAll(lang, "_", "-")) {
+	case "en-us", "en-ph", "en-ca", "es-us":
+		return MDY
+	}
+	return DMY
+}
+
+// isoLayouts are tried first, they are never ambiguous
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006/1/2",
+	"20060102",
+}
+
+// dmyLayouts are the numeric day first layouts, MDY layouts swap day and
+// month
+var dmyLayouts = []string{
+	"2/1/2006 15:04:05",
+	"2/1/2006 15:04",
+	"2/1/2006",
+	"2-1-2006 15:04:05",
+	"2-1-2006 15:04",
+	"2-1-2006",
+	"2.1.2006 15:04:05",
+	"2.1.2006",
+}
+
+// textLayouts have month names, they are never ambiguous
+var textLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.ANSIC,
+	time.UnixDate,
+	time.RubyDate,
+	"Jan 2 2006 15:04:05",
+	"Jan 2 2006 15:04",
+	"Jan 2 2006",
+	"Jan 2, 2006 15:04:05",
+	"Jan 2, 2006 15:04",
+	"Jan 2, 2006",
+	"January 2 2006",
+	"January 2, 2006",
+	"Mon, Jan 2, 2006",
+	"Monday, January 2, 2006",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 Jan 2006",
+	"2 January 2006",
+	"02-Jan-2006",
+}
+
+// ParseAny parses dates in the many formats users type, reading numeric
+// dates day first (DMY), see ParseAnyOrder
+func ParseAny(s, defaultTZ string) (time.Time, string, error) {
+	return ParseAnyOrder(s, defaultTZ, DMY)
+}
+
+// ParseAnyOrder parses s trying, in order:
+//
+//   - integers as epoch timestamps, whose unit is guessed by Guess. 8 digit
+//     integers are dates (20180821) rather than seconds of 1970.
+//   - ISO 8601 dates and times: 2018-08-21, 2018-08-21T15:04:05.999+07:00,
+//     2018-08-21 15:04, 2018/08/21
+//   - numeric dates separated by /, - or . with optional time:
+//     21/08/2018, 21-08-2018 15:04, 21.08.2018. When both numbers are
+//     12 or less, order tells which one is the day, see DateOrderOf.
+//   - dates with English month names: Aug 21 2018, Aug 21, 2018 15:04,
+//     21 Aug 2018, August 21, 2018, 21-Aug-2018 and the RFC 1123, RFC 850,
+//     ANSI C, Unix and Ruby formats of package time
+//
+// Dates without timezone are in defaultTZ, a name (e.g: "Asia/Ho_Chi_Minh")
+// or an offset (e.g: "+07:00"). The time is returned in defaultTZ along with
+// the layout that matched, in the syntax of package time, or "unix s", "unix
+// ms", "unix us" or "unix ns" for epochs.
+func ParseAnyOrder(s, defaultTZ string, order DateOrder) (time.Time, string, error) {
+	loc, err := LoadLocation(defaultTZ)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	s = strings.Join(strings.Fields(s), " ")
+
+	if isInteger(s) && (len(s) != 8 || s[0] == '-') {
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, "", fmt.Errorf("clock: cannot parse date %q: %w", s, err)
+		}
+		ts := Guess(i)
+		return ts.Time().In(loc), "unix " + ts.Unit().String(), nil
+	}
+
+	layouts := make([]string, 0, len(isoLayouts)+2*len(dmyLayouts)+len(textLayouts))
+	layouts = append(layouts, isoLayouts...)
+	first, second := dmyLayouts, mdyLayouts
+	if order == MDY {
+		first, second = mdyLayouts, dmyLayouts
+	}
+	// the other order still parses dates whose day is greater than 12
+	layouts = append(layouts, first...)
+	layouts = append(layouts, second...)
+	layouts = append(layouts, textLayouts...)
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t.In(loc), layout, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("clock: cannot parse date %q", s)
+}
+
+// mdyLayouts are dmyLayouts with month first
+var mdyLayouts = func() []string {
+	out := make([]string, len(dmyLayouts))
+	for i, layout := range dmyLayouts {
+		// 2/1/2006 -> 1/2/2006
+		out[i] = strings.Replace(layout, "2"+layout[1:2]+"1", "1"+layout[1:2]+"2", 1)
+	}
+	return out
+}()
+
+func isInteger(s string) bool {
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNumeric(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseAny(t *testing.T) {
+	tcs := []struct {
+		in     string
+		tz     string
+		order  DateOrder
+		out    string // RFC3339Nano
+		layout string
+	}{
+		{"21/08/2018", "+07:00", DMY, "2018-08-21T00:00:00+07:00", "2/1/2006"},
+		{"21/08/2018", "+07:00", MDY, "2018-08-21T00:00:00+07:00", "2/1/2006"},
+		{"08/21/2018", "+07:00", DMY, "2018-08-21T00:00:00+07:00", "1/2/2006"},
+		{"03/04/2018", "UTC", DMY, "2018-04-03T00:00:00Z", "2/1/2006"},
+		{"03/04/2018", "UTC", MDY, "2018-03-04T00:00:00Z", "1/2/2006"},
+		{"3-4-2018 15:04", "UTC", DateOrderOf("en_US"), "2018-03-04T15:04:00Z", "1-2-2006 15:04"},
+		{"21.08.2018", "UTC", DMY, "2018-08-21T00:00:00Z", "2.1.2006"},
+		{"2018-08-21T15:04:00+07:00", "UTC", DMY, "2018-08-21T08:04:00Z", time.RFC3339Nano},
+		{"2018-08-21T15:04:05.123Z", "UTC", DMY, "2018-08-21T15:04:05.123Z", time.RFC3339Nano},
+		{"2018-08-21 15:04", "Asia/Ho_Chi_Minh", DMY, "2018-08-21T15:04:00+07:00", "2006-01-02 15:04"},
+		{"2018-08-21", "America/New_York", DMY, "2018-08-21T00:00:00-04:00", "2006-01-02"},
+		{"2018/8/21", "UTC", DMY, "2018-08-21T00:00:00Z", "2006/1/2"},
+		{"20180821", "UTC", DMY, "2018-08-21T00:00:00Z", "20060102"},
+		{"Aug 21 2018", "UTC", DMY, "2018-08-21T00:00:00Z", "Jan 2 2006"},
+		{" aug  21, 2018 ", "UTC", DMY, "2018-08-21T00:00:00Z", "Jan 2, 2006"},
+		{"21 August 2018", "UTC", DMY, "2018-08-21T00:00:00Z", "2 January 2006"},
+		{"Tue, 21 Aug 2018 15:04:05 +0700", "UTC", DMY, "2018-08-21T08:04:05Z", time.RFC1123Z},
+		{"1534838640", "+07:00", DMY, "2018-08-21T15:04:00+07:00", "unix s"},
+		{"1534838640123", "UTC", DMY, "2018-08-21T08:04:00.123Z", "unix ms"},
+		{"1534838640123456789", "UTC", DMY, "2018-08-21T08:04:00.123456789Z", "unix ns"},
+		{"0", "UTC", DMY, "1970-01-01T00:00:00Z", "unix s"},
+	}
+	for _, tc := range tcs {
+		out, layout, err := ParseAnyOrder(tc.in, tc.tz, tc.order)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.in, err)
+			continue
+		}
+		if s := out.Format(time.RFC3339Nano); s != tc.out || layout != tc.layout {
+			t.Errorf("%q: should be %s %q, got %s %q", tc.in, tc.out, tc.layout, s, layout)
+		}
+	}
+
+	if out, _, err := ParseAny("03/04/2018", "UTC"); err != nil || out.Month() != time.April {
+		t.Errorf("should read day first, got %v %v", out, err)
+	}
+
+	for _, in := range []string{"", "abc", "13/13/2018", "32/01/2018", "20181321", "99999999999999999999", "2018-08-21T25:00"} {
+		if out, layout, err := ParseAny(in, "UTC"); err == nil {
+			t.Errorf("%q: should fail, got %v %q", in, out, layout)
+		}
+	}
+	if _, _, err := ParseAny("21/08/2018", "Mars/Base"); err == nil {
+		t.Error("unknown timezone should fail")
+	}
+}
@@ -0,0 +1,235 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits. Code depending on time should take a Clock
+// so tests can replace Real by a FakeClock.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a time.Timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker created by a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+// OrReal returns c, or Real when c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// FakeClock is a Clock for tests. Its time only moves when Advance or Set is
+// called, which fires the timers, tickers and sleeps that are due, in order.
+// Like time.Timer, channels have a buffer of one and ticks are dropped when
+// the receiver is late.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+	changed chan struct{} // closed when waiters change, see BlockUntil
+}
+
+// NewFakeClock returns a FakeClock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+// waiter is a timer, ticker (period > 0) or sleep of a FakeClock
+type waiter struct {
+	c      chan time.Time
+	clock  *FakeClock
+	at     time.Time
+	period time.Duration
+	active bool
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Sleep blocks until the clock is advanced by d
+func (f *FakeClock) Sleep(d time.Duration) { <-f.After(d) }
+
+func (f *FakeClock) After(d time.Duration) <-chan time.Time { return f.NewTimer(d).C() }
+
+func (f *FakeClock) NewTimer(d time.Duration) Timer { return &fakeTimer{f.add(d, 0)} }
+
+// NewTicker returns a ticker firing every d, d must be positive
+func (f *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return &fakeTicker{f.add(d, d)}
+}
+
+func (f *FakeClock) add(d, period time.Duration) *waiter {
+	t := &waiter{c: make(chan time.Time, 1), clock: f, period: period}
+	f.mu.Lock()
+	f.schedule(t, d)
+	f.mu.Unlock()
+	// timers due now fire right away
+	f.Advance(0)
+	return t
+}
+
+// schedule activates t to fire after d, f.mu must be held
+func (f *FakeClock) schedule(t *waiter, d time.Duration) bool {
+	wasActive := t.active
+	t.at, t.active = f.now.Add(d), true
+	if !wasActive {
+		f.waiters = append(f.waiters, t)
+	}
+	f.notify()
+	return wasActive
+}
+
+// unschedule deactivates t, f.mu must be held
+func (f *FakeClock) unschedule(t *waiter) bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	for i, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			break
+		}
+	}
+	f.notify()
+	return true
+}
+
+// notify wakes up BlockUntil, f.mu must be held
+func (f *FakeClock) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// Advance moves the clock forward by d, firing what is due on the way
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	end := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+		if len(f.waiters) == 0 || f.waiters[0].at.After(end) {
+			break
+		}
+		t := f.waiters[0]
+		if t.at.After(f.now) {
+			f.now = t.at
+		}
+		select {
+		case t.c <- f.now:
+		default: // the receiver is late, drop the tick
+		}
+		if t.period > 0 {
+			t.at = t.at.Add(t.period)
+			continue
+		}
+		f.unschedule(t)
+	}
+	f.now = end
+}
+
+// Set moves the clock to t, firing what is due on the way when t is after
+// the current time
+func (f *FakeClock) Set(t time.Time) { f.Advance(t.Sub(f.Now())) }
+
+// Waiters returns the number of active timers, tickers and sleeps
+func (f *FakeClock) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until there are n active timers, tickers and sleeps, so
+// tests can advance the clock once the code under test is waiting
+func (f *FakeClock) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		count, changed := len(f.waiters), f.changed
+		f.mu.Unlock()
+		if count == n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (t *waiter) stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *waiter) reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	active := t.clock.schedule(t, d)
+	if t.period > 0 {
+		t.period = d
+	}
+	t.clock.mu.Unlock()
+	t.clock.Advance(0)
+	return active
+}
+
+type fakeTimer struct{ w *waiter }
+
+func (t *fakeTimer) C() <-chan time.Time        { return t.w.c }
+func (t *fakeTimer) Stop() bool                 { return t.w.stop() }
+func (t *fakeTimer) Reset(d time.Duration) bool { return t.w.reset(d) }
+
+type fakeTicker struct{ w *waiter }
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t *fakeTicker) Stop()               { t.w.stop() }
+
+// Reset stops the ticker and resets its period to d, d must be positive
+func (t *fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Ticker.Reset")
+	}
+	t.w.reset(d)
+}
@@ -0,0 +1,102 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+
+	timer := c.NewTimer(10 * time.Second)
+	ticker := c.NewTicker(4 * time.Second)
+	after := c.After(5 * time.Second)
+	if c.Waiters() != 3 {
+		t.Fatalf("should have 3 waiters, got %d", c.Waiters())
+	}
+
+	c.Advance(3 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatalf("should not fire early")
+	case <-ticker.C():
+		t.Fatalf("should not tick early")
+	case <-after:
+		t.Fatalf("should not fire early")
+	default:
+	}
+
+	c.Advance(2 * time.Second)
+	if at := <-ticker.C(); !at.Equal(start.Add(4 * time.Second)) {
+		t.Errorf("should tick at +4s, got %v", at)
+	}
+	if at := <-after; !at.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("should fire at +5s, got %v", at)
+	}
+	if !c.Now().Equal(start.Add(5 * time.Second)) {
+		t.Errorf("should be at +5s, got %v", c.Now())
+	}
+
+	// the timer is pushed back
+	if !timer.Reset(10 * time.Second) {
+		t.Errorf("should reset an active timer")
+	}
+	c.Advance(6 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatalf("should be reset")
+	default:
+	}
+	// ticks at +8s and +12s, the second one is dropped since nobody received
+	// the first one
+	if at := <-ticker.C(); !at.Equal(start.Add(8 * time.Second)) {
+		t.Errorf("should tick at +8s, got %v", at)
+	}
+	ticker.Stop()
+	c.Advance(4 * time.Second)
+	if at := <-timer.C(); !at.Equal(start.Add(15 * time.Second)) {
+		t.Errorf("should fire at +15s, got %v", at)
+	}
+	select {
+	case <-ticker.C():
+		t.Errorf("should not tick once stopped")
+	default:
+	}
+	if timer.Stop() || c.Waiters() != 0 {
+		t.Errorf("should have no waiter left, got %d", c.Waiters())
+	}
+
+	// sleeps return once the clock is advanced
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Hour)
+		close(done)
+	}()
+	c.BlockUntil(1)
+	c.Advance(time.Hour)
+	<-done
+
+	c.Set(start)
+	if !c.Now().Equal(start) {
+		t.Errorf("should be set, got %v", c.Now())
+	}
+	if <-c.After(0); c.Waiters() != 0 {
+		t.Errorf("should fire zero timers right away")
+	}
+}
+
+func TestRealClock(t *testing.T) {
+	c := OrReal(nil)
+	before := time.Now()
+	timer := c.NewTimer(time.Millisecond)
+	<-timer.C()
+	ticker := c.NewTicker(time.Millisecond)
+	<-ticker.C()
+	ticker.Stop()
+	<-c.After(time.Millisecond)
+	c.Sleep(time.Millisecond)
+	if c.Now().Sub(before) < 3*time.Millisecond {
+		t.Errorf("should wait")
+	}
+}
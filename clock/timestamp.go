@@ -0,0 +1,163 @@
+package clock
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Unit is the unit of an integer timestamp, its value is the number of
+// nanoseconds per unit. The zero Unit is Milli.
+type Unit int64
+
+const (
+	Nano  Unit = 1
+	Micro Unit = 1e3
+	Milli Unit = 1e6
+	Sec   Unit = 1e9
+)
+
+func (u Unit) or() Unit {
+	if u <= 0 {
+		return Milli
+	}
+	return u
+}
+
+func (u Unit) String() string {
+	switch u.or() {
+	case Nano:
+		return "ns"
+	case Micro:
+		return "us"
+	case Milli:
+		return "ms"
+	case Sec:
+		return "s"
+	}
+	return strconv.FormatInt(int64(u), 10) + "ns"
+}
+
+// Timestamp is an instant together with the unit of its integer form, used
+// when encoding it, e.g: FromSec(1710072000) is encoded in seconds
+type Timestamp struct {
+	t    time.Time
+	unit Unit
+}
+
+// FromSec returns the timestamp of sec seconds since 1/1/1970 UTC
+func FromSec(sec int64) Timestamp { return Timestamp{t: time.Unix(sec, 0).UTC(), unit: Sec} }
+
+// FromMilli returns the timestamp of ms milliseconds since 1/1/1970 UTC
+func FromMilli(ms int64) Timestamp { return Timestamp{t: time.UnixMilli(ms).UTC(), unit: Milli} }
+
+// FromMicro returns the timestamp of us microseconds since 1/1/1970 UTC
+func FromMicro(us int64) Timestamp { return Timestamp{t: time.UnixMicro(us).UTC(), unit: Micro} }
+
+// FromNano returns the timestamp of ns nanoseconds since 1/1/1970 UTC
+func FromNano(ns int64) Timestamp { return Timestamp{t: time.Unix(0, ns).UTC(), unit: Nano} }
+
+// FromTime returns the timestamp of t encoded in unit
+func FromTime(t time.Time, unit Unit) Timestamp { return Timestamp{t: t, unit: unit.or()} }
+
+// Guess returns the timestamp of t, guessing its unit from its magnitude:
+//
+//	t <= 1e12        seconds, up to year 33658
+//	1e12 < t <= 1e15 milliseconds, 2001-09-09T01:46:40Z to year 33658
+//	1e15 < t <= 1e18 microseconds, 2001-09-09T01:46:40Z to year 33658
+//	t > 1e18         nanoseconds, from 2001-09-09T01:46:40Z
+//
+// So milliseconds, microseconds and nanoseconds before 2001-09-09 are taken
+// for a larger unit, e.g: 1e12 is seconds, not milliseconds. Prefer FromSec,
+// FromMilli, FromMicro or FromNano when the unit is known.
+func Guess(t int64) Timestamp {
+	unit := guessUnit(t)
+	return Timestamp{t: fromUnit(t, unit), unit: unit}
+}
+
+// fromUnit returns the time of t units since 1/1/1970 UTC
+func fromUnit(t int64, unit Unit) time.Time {
+	perSec := int64(time.Second) / int64(unit)
+	return time.Unix(t/perSec, t%perSec*int64(unit)).UTC()
+}
+
+func guessUnit(t int64) Unit {
+	if t > 1e+18 {
+		return Nano
+	}
+	if t > 1e+15 {
+		return Micro
+	}
+	if t > 1e+12 {
+		return Milli
+	}
+	return Sec
+}
+
+// Time returns the instant of the timestamp
+func (ts Timestamp) Time() time.Time { return ts.t }
+
+// Unit returns the unit of the timestamp
+func (ts Timestamp) Unit() Unit { return ts.unit.or() }
+
+// In returns the same instant encoded in unit
+func (ts Timestamp) In(unit Unit) Timestamp { return Timestamp{t: ts.t, unit: unit.or()} }
+
+// IsZero tells whether the timestamp is the zero time
+func (ts Timestamp) IsZero() bool { return ts.t.IsZero() }
+
+// Int64 returns the number of units since 1/1/1970 UTC, truncated toward
+// zero. Nanoseconds overflow after year 2262.
+func (ts Timestamp) Int64() int64 {
+	unit := ts.unit.or()
+	if unit == Nano {
+		return ts.t.UnixNano()
+	}
+	sec := ts.t.Unix()
+	nsec := int64(ts.t.Nanosecond())
+	if sec < 0 && nsec > 0 {
+		// truncate toward zero like integer division
+		sec++
+		nsec -= int64(time.Second)
+	}
+	return sec*(int64(time.Second)/int64(unit)) + nsec/int64(unit)
+}
+
+// UnixNano returns the number of nanoseconds since 1/1/1970 UTC
+func (ts Timestamp) UnixNano() int64 { return ts.t.UnixNano() }
+
+func (ts Timestamp) String() string {
+	return strconv.FormatInt(ts.Int64(), 10) + ts.Unit().String()
+}
+
+// MarshalJSON encodes the timestamp as an integer in its unit, the zero
+// timestamp as null
+func (ts Timestamp) MarshalJSON() ([]byte, error) {
+	if ts.IsZero() {
+		return []byte("null"), nil
+	}
+	return strconv.AppendInt(nil, ts.Int64(), 10), nil
+}
+
+// UnmarshalJSON decodes an integer in the unit of ts, which should be set
+// before decoding (the zero Unit is Milli), e.g:
+//
+//	ev := Event{Created: clock.Timestamp{}.In(clock.Sec)}
+//	json.Unmarshal(data, &ev)
+func (ts *Timestamp) UnmarshalJSON(data []byte) error {
+	unit := ts.unit.or()
+	if string(data) == "null" {
+		*ts = Timestamp{unit: unit}
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return err
+	}
+	ts.t, ts.unit = fromUnit(i, unit), unit
+	return nil
+}
@@ -0,0 +1,116 @@
+package clock
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestGuess(t *testing.T) {
+	tcs := []struct {
+		t    int64
+		unit Unit
+		want string
+	}{
+		{0, Sec, "1970-01-01T00:00:00Z"},
+		{-86400, Sec, "1969-12-31T00:00:00Z"},
+		{1710072000, Sec, "2024-03-10T12:00:00Z"},
+		{1e12, Sec, "33658-09-27T01:46:40Z"},
+		{1e12 + 1, Milli, "2001-09-09T01:46:40.001Z"},
+		{1710072000000, Milli, "2024-03-10T12:00:00Z"},
+		{1e15, Milli, "33658-09-27T01:46:40Z"},
+		{1e15 + 1, Micro, "2001-09-09T01:46:40.000001Z"},
+		{1710072000000000, Micro, "2024-03-10T12:00:00Z"},
+		{1e18, Micro, "33658-09-27T01:46:40Z"},
+		{1e18 + 1, Nano, "2001-09-09T01:46:40.000000001Z"},
+		{1710072000000000000, Nano, "2024-03-10T12:00:00Z"},
+	}
+	for _, tc := range tcs {
+		ts := Guess(tc.t)
+		if ts.Unit() != tc.unit || ts.Time().Format(time.RFC3339Nano) != tc.want {
+			t.Errorf("%d: should be %s in %s, got %s in %s", tc.t, tc.want, tc.unit,
+				ts.Time().Format(time.RFC3339Nano), ts.Unit())
+		}
+		if ts.Int64() != tc.t {
+			t.Errorf("%d: should keep the value, got %d", tc.t, ts.Int64())
+		}
+		if tc.t < 1e12 && UnixNano(tc.t) != ts.UnixNano() {
+			t.Errorf("%d: UnixNano should guess like Guess, got %d", tc.t, UnixNano(tc.t))
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	at := time.Date(2024, 3, 10, 12, 0, 0, 123456789, time.UTC)
+	tcs := []struct {
+		ts   Timestamp
+		i    int64
+		unit Unit
+	}{
+		{FromSec(at.Unix()), 1710072000, Sec},
+		{FromMilli(at.UnixMilli()), 1710072000123, Milli},
+		{FromMicro(at.UnixMicro()), 1710072000123456, Micro},
+		{FromNano(at.UnixNano()), 1710072000123456789, Nano},
+		{FromTime(at, Sec), 1710072000, Sec},
+		{FromTime(at, 0), 1710072000123, Milli},
+		{FromNano(at.UnixNano()).In(Micro), 1710072000123456, Micro},
+		// before 2001-09-09 in milliseconds, which Guess takes for seconds
+		{FromMilli(86400000), 86400000, Milli},
+		{FromMilli(-1500), -1500, Milli},
+		{FromTime(time.UnixMilli(-1500), Sec), -1, Sec},
+	}
+	for _, tc := range tcs {
+		if tc.ts.Int64() != tc.i || tc.ts.Unit() != tc.unit {
+			t.Errorf("%v: should be %d in %s, got %d in %s", tc.ts.Time(), tc.i, tc.unit, tc.ts.Int64(), tc.ts.Unit())
+		}
+	}
+	if FromMilli(86400000).Time() != time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("should not guess the unit, got %v", FromMilli(86400000).Time())
+	}
+	if s := FromSec(1710072000).String(); s != "1710072000s" {
+		t.Errorf("should be 1710072000s, got %s", s)
+	}
+}
+
+func TestTimestampJSON(t *testing.T) {
+	type event struct {
+		Created Timestamp  `json:"created"`
+		Updated Timestamp  `json:"updated"`
+		Deleted *Timestamp `json:"deleted,omitempty"`
+	}
+	ev := event{Created: FromSec(1710072000), Updated: FromMilli(1710072000123)}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"created":1710072000,"updated":1710072000123}` {
+		t.Errorf("should encode in the unit of each timestamp, got %s", b)
+	}
+
+	// the unit is configured before decoding, Updated is Milli by default
+	out := event{Created: Timestamp{}.In(Sec)}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.Created.Time().Equal(ev.Created.Time()) || out.Created.Unit() != Sec ||
+		!out.Updated.Time().Equal(ev.Updated.Time()) || out.Updated.Unit() != Milli {
+		t.Errorf("should round trip, got %v %v", out.Created, out.Updated)
+	}
+
+	var zero event
+	if err := json.Unmarshal([]byte(`{"created":null,"updated":253402300800000}`), &zero); err != nil {
+		t.Fatal(err)
+	}
+	if !zero.Created.IsZero() || zero.Updated.Time().Year() != 10000 {
+		t.Errorf("should decode null and far timestamps, got %v %v", zero.Created.Time(), zero.Updated.Time())
+	}
+	if b, _ := json.Marshal(zero.Created); string(b) != "null" {
+		t.Errorf("should encode zero timestamp as null, got %s", b)
+	}
+	for _, invalid := range []string{`"2024-03-10"`, `1.5`, `true`} {
+		var ts Timestamp
+		if err := json.Unmarshal([]byte(invalid), &ts); err == nil {
+			t.Errorf("%s: should fail", invalid)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// Job is the work of a scheduled entry, ctx is canceled when Stop gives up
+// waiting
+type Job func(ctx context.Context)
+
+// Overlap tells what to do when a job is due while its previous run is still
+// running
+type Overlap int
+
+const (
+	Skip  Overlap = iota // drop the new run
+	Queue                // run after the running ones, in order
+	Allow                // run concurrently
+)
+
+// Scheduler runs jobs on cron schedules. The zero Scheduler is ready to use.
+type Scheduler struct {
+	Clock   clock.Clock                  // default to clock.Real
+	TZ      string                       // timezone of specs without CRON_TZ=, default to UTC
+	OnPanic func(name string, err error) // called with recovered panics of jobs, default to logging them
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	stopped bool
+	wg      sync.WaitGroup // entry loops and running jobs
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+type entry struct {
+	name     string
+	schedule *Schedule
+	overlap  Overlap
+	job      Job
+	done     chan struct{} // closed when removed
+
+	mu      sync.Mutex
+	running int // runs in progress
+	pending int // queued runs
+}
+
+// Add schedules job under unique name with cron expression spec, see Parse
+func (s *Scheduler) Add(name, spec string, overlap Overlap, job Job) error {
+	schedule, err := Parse(spec, s.TZ)
+	if err != nil {
+		return err
+	}
+	return s.AddSchedule(name, schedule, overlap, job)
+}
+
+// AddSchedule schedules job under unique name
+func (s *Scheduler) AddSchedule(name string, schedule *Schedule, overlap Overlap, job Job) error {
+	if job == nil || schedule == nil {
+		return fmt.Errorf("cron: invalid job %s", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return fmt.Errorf("cron: scheduler is stopped")
+	}
+	if s.entries == nil {
+		s.entries = map[string]*entry{}
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	if _, has := s.entries[name]; has {
+		return fmt.Errorf("cron: job %s is already added", name)
+	}
+	e := &entry{name: name, schedule: schedule, overlap: overlap, job: job, done: make(chan struct{})}
+	s.entries[name] = e
+	s.wg.Add(1)
+	go s.loop(e)
+	return nil
+}
+
+// Remove unschedules job name, its running and queued runs still complete.
+// It returns false when there is no such job.
+func (s *Scheduler) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, has := s.entries[name]
+	if !has {
+		return false
+	}
+	delete(s.entries, name)
+	close(e.done)
+	return true
+}
+
+// Stop stops scheduling runs and waits for the running ones until ctx is
+// done, in which case the context of jobs is canceled and ctx.Err() is
+// returned. Queued runs which have not started are dropped.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		for name, e := range s.entries {
+			delete(s.entries, name)
+			close(e.done)
+		}
+	}
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if cancel != nil {
+			cancel()
+		}
+		return nil
+	case <-ctx.Done():
+		if cancel != nil {
+			cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+// loop fires e at its schedule until it is removed
+func (s *Scheduler) loop(e *entry) {
+	defer s.wg.Done()
+	clk := clock.OrReal(s.Clock)
+	now := clk.Now()
+	for {
+		next := e.schedule.Next(now)
+		if next.IsZero() {
+			return
+		}
+		timer := clk.NewTimer(next.Sub(clk.Now()))
+		select {
+		case <-e.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		// runs missed while the process was asleep are not caught up
+		if now = clk.Now(); now.Before(next) {
+			now = next
+		}
+		s.fire(e)
+	}
+}
+
+// fire starts a run of e according to its overlap policy
+func (s *Scheduler) fire(e *entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.running > 0 && e.overlap != Allow {
+		if e.overlap == Queue {
+			e.pending++
+		}
+		return
+	}
+	e.running++
+	s.wg.Add(1)
+	go s.run(e)
+}
+
+// run runs e then its queued runs
+func (s *Scheduler) run(e *entry) {
+	defer s.wg.Done()
+	for {
+		s.call(e)
+
+		stopped := s.isStopped()
+		e.mu.Lock()
+		if e.pending > 0 && !stopped {
+			e.pending--
+			e.mu.Unlock()
+			continue
+		}
+		e.pending = 0
+		e.running--
+		e.mu.Unlock()
+		return
+	}
+}
+
+func (s *Scheduler) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// call calls the job of e, recovering panics
+func (s *Scheduler) call(e *entry) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, _ := r.(error)
+			if err == nil {
+				err = fmt.Errorf("%v", r)
+			}
+			if s.OnPanic != nil {
+				s.OnPanic(e.name, err)
+				return
+			}
+			log.Println("cron: job", e.name, "panicked:", err)
+		}
+	}()
+	e.job(s.ctx)
+}
+
+// Next returns the next fire time of job name, false when there is no such
+// job
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.mu.Lock()
+	e, has := s.entries[name]
+	s.mu.Unlock()
+	if !has {
+		return time.Time{}, false
+	}
+	return e.schedule.Next(clock.OrReal(s.Clock).Now()), true
+}
@@ -0,0 +1,270 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+func TestParse(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"*/15 9-17 * * MON-FRI",
+		"0 30 9 1,15 * ?",
+		"0 0 1 jan,Jul *",
+		"5/10 * * * *",
+		"0 0 * * 7",
+		"@daily",
+		"@Hourly",
+		"CRON_TZ=Asia/Ho_Chi_Minh 0 9 * * *",
+		"TZ=+07:00 0 9 * * *",
+	}
+	for _, spec := range valid {
+		if _, err := Parse(spec, ""); err != nil {
+			t.Errorf("%q: unexpected error %v", spec, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"? * * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@every",
+		"CRON_TZ=Mars/Base * * * * *",
+		"CRON_TZ=UTC",
+	}
+	for _, spec := range invalid {
+		if _, err := Parse(spec, ""); err == nil {
+			t.Errorf("%q: should fail", spec)
+		}
+	}
+	if _, err := Parse("* * * * *", "Mars/Base"); err == nil {
+		t.Error("unknown timezone should fail")
+	}
+}
+
+func TestNext(t *testing.T) {
+	tcs := []struct {
+		spec string
+		tz   string
+		from string
+		next []string
+	}{
+		{"*/20 * * * *", "", "2024-03-10T00:00:00Z", []string{"2024-03-10T00:20:00Z", "2024-03-10T00:40:00Z", "2024-03-10T01:00:00Z"}},
+		{"30 */20 * * * *", "", "2024-03-10T00:00:30Z", []string{"2024-03-10T00:20:30Z", "2024-03-10T00:40:30Z"}},
+		{"0 9 * * MON-FRI", "+07:00", "2024-03-08T10:00:00+07:00", []string{"2024-03-11T09:00:00+07:00", "2024-03-12T09:00:00+07:00"}},
+		{"CRON_TZ=Asia/Ho_Chi_Minh 0 9 * * *", "America/New_York", "2024-03-10T00:00:00Z", []string{"2024-03-10T09:00:00+07:00"}},
+		{"@monthly", "", "2024-01-31T12:00:00Z", []string{"2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z"}},
+		{"0 0 29 2 *", "", "2024-03-01T00:00:00Z", []string{"2028-02-29T00:00:00Z"}},
+		// either day of month or day of week when both are set
+		{"0 0 13 * FRI", "", "2024-09-01T00:00:00Z", []string{"2024-09-06T00:00:00Z", "2024-09-13T00:00:00Z", "2024-09-20T00:00:00Z"}},
+		{"0 0 * * 0", "", "2024-09-01T00:00:00Z", []string{"2024-09-08T00:00:00Z"}},
+		// */2 counts as *, so both days must match: odd days which are Mondays
+		{"0 0 */2 * 1", "", "2024-09-01T00:00:00Z", []string{"2024-09-09T00:00:00Z", "2024-09-23T00:00:00Z", "2024-10-07T00:00:00Z"}},
+		{"0 0 * * 7", "", "2024-09-01T00:00:00Z", []string{"2024-09-08T00:00:00Z"}},
+		{"0 0 30 2 *", "", "2024-01-01T00:00:00Z", nil},
+
+		// clocks go forward at 02:00 in New York on March 10 2024
+		{"30 2 * * *", "America/New_York", "2024-03-09T12:00:00-05:00", []string{"2024-03-10T03:30:00-04:00", "2024-03-11T02:30:00-04:00"}},
+		{"0 * * * *", "America/New_York", "2024-03-10T00:30:00-05:00", []string{"2024-03-10T01:00:00-05:00", "2024-03-10T03:00:00-04:00", "2024-03-10T04:00:00-04:00"}},
+		{"30 2,3 * * *", "America/New_York", "2024-03-10T00:00:00-05:00", []string{"2024-03-10T03:30:00-04:00", "2024-03-11T02:30:00-04:00"}},
+		// clocks go back at 02:00 in New York on November 3 2024
+		{"30 1 * * *", "America/New_York", "2024-11-02T12:00:00-04:00", []string{"2024-11-03T01:30:00-04:00", "2024-11-04T01:30:00-05:00"}},
+		{"0 * * * *", "America/New_York", "2024-11-03T00:30:00-04:00", []string{"2024-11-03T01:00:00-04:00", "2024-11-03T02:00:00-05:00"}},
+		{"*/30 * * * *", "America/New_York", "2024-11-03T01:10:00-05:00", []string{"2024-11-03T02:00:00-05:00"}},
+	}
+	for _, tc := range tcs {
+		s, err := Parse(tc.spec, tc.tz)
+		if err != nil {
+			t.Fatalf("%q: unexpected error %v", tc.spec, err)
+		}
+		from, _ := time.Parse(time.RFC3339, tc.from)
+		var out []string
+		for _, next := range s.NextN(from, len(tc.next)+1) {
+			out = append(out, next.Format(time.RFC3339))
+		}
+		if len(out) > len(tc.next) {
+			out = out[:len(tc.next)]
+		}
+		if strings.Join(out, " ") != strings.Join(tc.next, " ") {
+			t.Errorf("%q from %s: should be %v, got %v", tc.spec, tc.from, tc.next, out)
+		}
+	}
+}
+
+// tick waits for the scheduler to wait on n timers then advances clk by d
+func tick(clk *clock.FakeClock, n int, d time.Duration) {
+	clk.BlockUntil(n)
+	clk.Advance(d)
+}
+
+func TestScheduler(t *testing.T) {
+	clk := clock.NewFakeClock(time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC))
+	panics := make(chan string, 10)
+	s := &Scheduler{Clock: clk, OnPanic: func(name string, err error) { panics <- name + ": " + err.Error() }}
+
+	runs := make(chan time.Time, 10)
+	if err := s.Add("report", "*/5 * * * *", Skip, func(ctx context.Context) { runs <- clk.Now() }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("report", "* * * * *", Skip, func(ctx context.Context) {}); err == nil {
+		t.Error("duplicated job should fail")
+	}
+	if err := s.Add("bad", "* * *", Skip, func(ctx context.Context) {}); err == nil {
+		t.Error("invalid spec should fail")
+	}
+	if err := s.Add("panic", "*/5 * * * *", Skip, func(ctx context.Context) { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	if next, ok := s.Next("report"); !ok || !next.Equal(time.Date(2024, 3, 10, 0, 5, 0, 0, time.UTC)) {
+		t.Errorf("should be next at 00:05, got %v %v", next, ok)
+	}
+
+	tick(clk, 2, 4*time.Minute)
+	select {
+	case at := <-runs:
+		t.Fatalf("should not run yet, ran at %v", at)
+	default:
+	}
+	tick(clk, 2, time.Minute)
+	if at := <-runs; !at.Equal(time.Date(2024, 3, 10, 0, 5, 0, 0, time.UTC)) {
+		t.Errorf("should run at 00:05, got %v", at)
+	}
+	if p := <-panics; p != "panic: boom" {
+		t.Errorf("should recover panic, got %s", p)
+	}
+
+	// still scheduled after panicking
+	tick(clk, 2, 5*time.Minute)
+	<-runs
+	<-panics
+	if !s.Remove("panic") || s.Remove("panic") {
+		t.Error("should remove once")
+	}
+	tick(clk, 1, 5*time.Minute)
+	<-runs
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(panics) != 0 {
+		t.Errorf("removed job should not run, got %d more panics", len(panics))
+	}
+	if err := s.Add("late", "* * * * *", Skip, func(ctx context.Context) {}); err == nil {
+		t.Error("stopped scheduler should not accept jobs")
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	tcs := []struct {
+		overlap Overlap
+		runs    int
+		maxRuns int32 // concurrent runs
+	}{
+		{Skip, 1, 1},
+		{Queue, 3, 1},
+		{Allow, 3, 3},
+	}
+	for _, tc := range tcs {
+		clk := clock.NewFakeClock(time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC))
+		s := &Scheduler{Clock: clk}
+		release := make(chan struct{})
+		started := make(chan struct{}, 10)
+		var running, maxRunning int32
+		s.Add("job", "* * * * *", tc.overlap, func(ctx context.Context) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			started <- struct{}{}
+			<-release
+			atomic.AddInt32(&running, -1)
+		})
+
+		// 3 fires while the first run is blocked
+		tick(clk, 1, time.Minute)
+		<-started
+		tick(clk, 1, time.Minute)
+		tick(clk, 1, time.Minute)
+		clk.BlockUntil(1)
+		if tc.overlap == Allow {
+			<-started
+			<-started
+		}
+		close(release)
+		if tc.overlap == Queue {
+			<-started
+			<-started
+		}
+		s.Remove("job")
+		if err := s.Stop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(started); n != 0 {
+			t.Errorf("%d: should run %d times, got %d more runs", tc.overlap, tc.runs, n)
+		}
+		if maxRunning != tc.maxRuns {
+			t.Errorf("%d: should run %d at once, got %d", tc.overlap, tc.maxRuns, maxRunning)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	clk := clock.NewFakeClock(time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC))
+	s := &Scheduler{Clock: clk}
+	started, done := make(chan struct{}), make(chan error, 1)
+	s.Add("slow", "* * * * *", Skip, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		done <- ctx.Err()
+	})
+	tick(clk, 1, time.Minute)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("should give up waiting, got %v", err)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("job context should be canceled, got %v", err)
+	}
+
+	// graceful
+	s = &Scheduler{Clock: clk}
+	release, finished := make(chan struct{}), int32(0)
+	s.Add("job", "* * * * *", Queue, func(ctx context.Context) {
+		<-release
+		atomic.AddInt32(&finished, 1)
+	})
+	tick(clk, 1, time.Minute)
+	tick(clk, 1, time.Minute) // queued, dropped by Stop
+	clk.BlockUntil(1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&finished); n != 1 {
+		t.Errorf("should wait for the running job only, got %d runs", n)
+	}
+}
@@ -0,0 +1,263 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// Schedule is a parsed cron expression, see Parse
+type Schedule struct {
+	spec string
+	loc  *time.Location
+
+	// bit i is set when value i matches
+	sec, min, hour, dom, month, dow uint64
+
+	// days match when both dom and dow match, or when either matches if
+	// neither starts with * (like Vixie cron, so */2 counts as *)
+	domStar, dowStar bool
+}
+
+// field describes the values of a cron field
+type field struct {
+	name     string
+	min, max int
+	names    []string // names of values from min, e.g: JAN
+	days     bool     // accepts ? like *
+}
+
+var (
+	secField   = field{name: "second", min: 0, max: 59}
+	minField   = field{name: "minute", min: 0, max: 59}
+	hourField  = field{name: "hour", min: 0, max: 23}
+	domField   = field{name: "day of month", min: 1, max: 31, days: true}
+	monthField = field{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// 7 is also Sunday
+	dowField = field{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}, days: true}
+)
+
+// macros are the shorthands accepted by Parse
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses cron expression spec in timezone tz, a name (e.g:
+// "Asia/Ho_Chi_Minh") or an offset (e.g: "+07:00"), default to UTC. The
+// expression has 5 fields:
+//
+//	minute hour day-of-month month day-of-week
+//
+// or 6 fields starting with second. Fields are * (or ? for days), values,
+// ranges (1-5) and steps (*/15, 0-30/10, 5/10) separated by commas, months
+// and days of week could be named (JAN-DEC, SUN-SAT), Sunday is 0 or 7.
+// @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly are
+// accepted too. A CRON_TZ= or TZ= prefix overrides tz, e.g:
+//
+//	CRON_TZ=America/New_York 30 9 * * MON-FRI
+func Parse(spec, tz string) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if strings.HasPrefix(expr, prefix) {
+			i := strings.IndexAny(expr, " \t")
+			if i < 0 {
+				return nil, fmt.Errorf("cron: missing fields in %q", spec)
+			}
+			tz, expr = expr[len(prefix):i], strings.TrimSpace(expr[i:])
+			break
+		}
+	}
+	loc, err := clock.LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(expr, "@") {
+		macro, ok := macros[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("cron: unknown macro %q", expr)
+		}
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron: %q should have 5 or 6 fields, got %d", spec, len(fields))
+	}
+
+	s := &Schedule{spec: spec, loc: loc}
+	sets := []*uint64{&s.sec, &s.min, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, f := range []field{secField, minField, hourField, domField, monthField, dowField} {
+		if *sets[i], err = f.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("cron: invalid %s in %q: %w", f.name, spec, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domStar = strings.HasPrefix(fields[3], "*") || fields[3] == "?"
+	s.dowStar = strings.HasPrefix(fields[5], "*") || fields[5] == "?"
+	return s, nil
+}
+
+// parse returns the bit set of values matched by expr
+func (f field) parse(expr string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case rng == "*" || (rng == "?" && f.days):
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if low, err = f.value(a); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(b); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			var err error
+			if low, err = f.value(rng); err != nil {
+				return 0, err
+			}
+			// 5/10 means from 5 to the end every 10
+			if !hasStep {
+				high = low
+			}
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// value parses a number or a name of f
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%d is out of range [%d, %d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (s *Schedule) String() string { return s.spec }
+
+// Location returns the timezone of the schedule
+func (s *Schedule) Location() *time.Location { return s.loc }
+
+// maxYears bounds the search of Next, e.g: 0 0 30 2 * never fires
+const maxYears = 5
+
+// Next returns the first fire time strictly after t, or the zero time when
+// there is none in the next 5 years. Fire times are wall times of the
+// schedule timezone, so around DST transitions:
+//
+//   - wall times skipped when clocks go forward fire at the same instant as
+//     the wall time one hour later, e.g: 02:30 fires at 03:30 in New York on
+//     March 10 2024, once even when both match.
+//   - wall times repeated when clocks go back fire once, at their first
+//     occurrence, e.g: 01:30 fires at 01:30 EDT but not at 01:30 EST in New
+//     York on November 3 2024.
+func (s *Schedule) Next(t time.Time) time.Time {
+	// search on wall times, encoded in UTC which has no DST
+	local := t.In(s.loc)
+	year, month, day := local.Date()
+	hour, min, sec := local.Clock()
+	w := time.Date(year, month, day, hour, min, sec, 0, time.UTC).Add(time.Second)
+	limit := w.AddDate(maxYears, 0, 0)
+
+	for w.Before(limit) {
+		if !has(s.month, int(w.Month())) {
+			w = time.Date(w.Year(), w.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.matchDay(w) {
+			w = time.Date(w.Year(), w.Month(), w.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !has(s.hour, w.Hour()) {
+			w = w.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !has(s.min, w.Minute()) {
+			w = w.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if !has(s.sec, w.Second()) {
+			w = w.Add(time.Second)
+			continue
+		}
+
+		out := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, s.loc)
+		if out.Hour() != w.Hour() || out.Minute() != w.Minute() {
+			// w is skipped, take it in the offset of the day before, e.g:
+			// 02:30 -05:00 is 03:30 -04:00
+			_, offset := out.AddDate(0, 0, -1).Zone()
+			out = w.Add(-time.Duration(offset) * time.Second).In(s.loc)
+		}
+		if out.After(t) {
+			return out
+		}
+		// a skipped or repeated wall time which is already past
+		w = w.Add(time.Second)
+	}
+	return time.Time{}
+}
+
+// NextN returns the next n fire times after t, fewer when the schedule
+// stops firing, see Next
+func (s *Schedule) NextN(t time.Time, n int) []time.Time {
+	var out []time.Time
+	for len(out) < n {
+		t = s.Next(t)
+		if t.IsZero() {
+			break
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+func (s *Schedule) matchDay(w time.Time) bool {
+	dom, dow := has(s.dom, w.Day()), has(s.dow, int(w.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func has(set uint64, v int) bool { return set&(1<<uint(v)) != 0 }
@@ -0,0 +1,119 @@
+package expression
+
+import "strconv"
+
+// Op is an operator of the expression language
+type Op uint8
+
+const (
+	OpOr         Op = iota + 1 // a or b, a || b
+	OpAnd                      // a and b, a && b
+	OpNot                      // not a, !a
+	OpNeg                      // -a
+	OpEq                       // a == b
+	OpNe                       // a != b
+	OpLt                       // a < b
+	OpLe                       // a <= b
+	OpGt                       // a > b
+	OpGe                       // a >= b
+	OpIn                       // a in [b, c], a in "abc"
+	OpContains                 // [a, b] contains a, "abc" contains "b"
+	OpStartsWith               // "abc" startsWith "a"
+	OpEndsWith                 // "abc" endsWith "c"
+	OpMatches                  // "abc" matches "^a.c$"
+	OpBetween                  // a between b and c, see BetweenExpr
+	OpAdd                      // a + b
+	OpSub                      // a - b
+)
+
+var opNames = [...]string{
+	OpOr:         "or",
+	OpAnd:        "and",
+	OpNot:        "not",
+	OpNeg:        "-",
+	OpEq:         "==",
+	OpNe:         "!=",
+	OpLt:         "<",
+	OpLe:         "<=",
+	OpGt:         ">",
+	OpGe:         ">=",
+	OpIn:         "in",
+	OpContains:   "contains",
+	OpStartsWith: "startsWith",
+	OpEndsWith:   "endsWith",
+	OpMatches:    "matches",
+	OpBetween:    "between",
+	OpAdd:        "+",
+	OpSub:        "-",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) && opNames[op] != "" {
+		return opNames[op]
+	}
+	return "op(" + strconv.Itoa(int(op)) + ")"
+}
+
+// Node is a node of the expression syntax tree, one of *Literal, *Ident,
+// *ListExpr, *CallExpr, *UnaryExpr, *BinaryExpr or *BetweenExpr
+type Node interface {
+	// Offset returns the byte offset of the node in the source
+	Offset() int
+}
+
+// Literal is a constant: null, true, false, a number or a string
+type Literal struct {
+	Value Value
+	Off   int
+}
+
+// Ident is a reference to a variable of the environment, e.g: user.country
+type Ident struct {
+	Name string
+	Off  int
+}
+
+// ListExpr is a list literal, e.g: ["email", "fb"]
+type ListExpr struct {
+	Elems []Node
+	Off   int
+}
+
+// CallExpr is a function call, e.g: lower(user.name)
+type CallExpr struct {
+	Func string
+	Args []Node
+	Off  int
+}
+
+// UnaryExpr is an operator applied to a single operand, e.g: not a
+type UnaryExpr struct {
+	Op  Op
+	X   Node
+	Off int
+}
+
+// BinaryExpr is an operator applied to two operands, e.g: a == b
+type BinaryExpr struct {
+	Op  Op
+	X   Node
+	Y   Node
+	Off int
+}
+
+// BetweenExpr tells whether X is in the closed range from Low to High, e.g:
+// createdAt between 2024-01-01 and 2024-02-01
+type BetweenExpr struct {
+	X    Node
+	Low  Node
+	High Node
+	Off  int
+}
+
+func (n *Literal) Offset() int     { return n.Off }
+func (n *Ident) Offset() int       { return n.Off }
+func (n *ListExpr) Offset() int    { return n.Off }
+func (n *CallExpr) Offset() int    { return n.Off }
+func (n *UnaryExpr) Offset() int   { return n.Off }
+func (n *BinaryExpr) Offset() int  { return n.Off }
+func (n *BetweenExpr) Offset() int { return n.Off }
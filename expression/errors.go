@@ -0,0 +1,157 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError is returned when the source is not a valid expression
+type SyntaxError struct {
+	Offset   int // byte offset in the source where the error is detected
+	Line     int // 1-based line of Offset
+	Column   int // 1-based column of Offset, in characters
+	Msg      string
+	Expected string // what the parser expected, e.g: ")", may be empty
+	Found    string // the offending token, e.g: identifier brand
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// position converts a byte offset of src to 1-based line and column
+func position(src string, off int) (line, column int) {
+	if off > len(src) {
+		off = len(src)
+	}
+	line, column = 1, 1
+	for _, r := range src[:off] {
+		if r == '\n' {
+			line++
+			column = 1
+			continue
+		}
+		column++
+	}
+	return line, column
+}
+
+// TypeError is returned when an operator is applied to values of kinds it
+// doesn't support, e.g: "a" < 1
+type TypeError struct {
+	Op    string // operator, or "result" when the expression is not a bool
+	Kinds []Kind // kinds of the operands
+}
+
+func (e *TypeError) Error() string {
+	kinds := make([]string, len(e.Kinds))
+	for i, k := range e.Kinds {
+		kinds[i] = k.String()
+	}
+	if e.Op == "result" {
+		return "expression must be bool, got " + strings.Join(kinds, ", ")
+	}
+	return fmt.Sprintf("invalid operation: %s on %s", e.Op, strings.Join(kinds, " and "))
+}
+
+// UndefinedError is returned when the expression references a variable the
+// environment doesn't have
+type UndefinedError struct {
+	Name string
+}
+
+func (e *UndefinedError) Error() string { return "undefined variable " + e.Name }
+
+// ValueError is returned when a variable holds a Go value which cannot be
+// used in expressions
+type ValueError struct {
+	Name  string
+	Value any
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("unsupported value %T of variable %s", e.Value, e.Name)
+}
+
+// RegexError is returned when the pattern of matches operator is not a valid
+// regular expression
+type RegexError struct {
+	Pattern string
+	Err     error
+}
+
+func (e *RegexError) Error() string {
+	return fmt.Sprintf("invalid pattern %q: %v", e.Pattern, e.Err)
+}
+
+func (e *RegexError) Unwrap() error { return e.Err }
+
+// UndefinedFuncError is returned when the expression calls a function which
+// is not registered
+type UndefinedFuncError struct {
+	Name string
+}
+
+func (e *UndefinedFuncError) Error() string { return "undefined function " + e.Name }
+
+// CallError is returned when a function is called with a wrong number of
+// arguments or fails
+type CallError struct {
+	Func string
+	Err  error
+}
+
+func (e *CallError) Error() string { return "call " + e.Func + ": " + e.Err.Error() }
+
+func (e *CallError) Unwrap() error { return e.Err }
+
+// DepthLimitError is returned when the syntax tree is nested deeper than
+// Limits.MaxDepth
+type DepthLimitError struct {
+	Limit int
+}
+
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("expression is nested too deeply, the limit is %d", e.Limit)
+}
+
+// NodeLimitError is returned when the syntax tree has more nodes than
+// Limits.MaxNodes
+type NodeLimitError struct {
+	Limit int
+}
+
+func (e *NodeLimitError) Error() string {
+	return fmt.Sprintf("expression is too long, the limit is %d terms", e.Limit)
+}
+
+// StringLimitError is returned when a string is longer than
+// Limits.MaxStringLen
+type StringLimitError struct {
+	Limit int
+	Len   int
+}
+
+func (e *StringLimitError) Error() string {
+	return fmt.Sprintf("string of %d bytes is too long, the limit is %d", e.Len, e.Limit)
+}
+
+// StepLimitError is returned when the evaluation takes more steps than
+// Limits.MaxSteps
+type StepLimitError struct {
+	Limit int
+}
+
+func (e *StepLimitError) Error() string {
+	return fmt.Sprintf("evaluation exceeds the limit of %d steps", e.Limit)
+}
+
+// CanceledError is returned when the context of the evaluation is canceled
+// or its deadline is exceeded
+type CanceledError struct {
+	Err error // context.Canceled or context.DeadlineExceeded
+}
+
+func (e *CanceledError) Error() string { return "evaluation canceled: " + e.Err.Error() }
+
+func (e *CanceledError) Unwrap() error { return e.Err }
@@ -0,0 +1,224 @@
+package expression
+
+import (
+	"sort"
+	"strings"
+)
+
+// Trace is the evaluation of a sub expression, returned by Explain
+type Trace struct {
+	Node  Node
+	Expr  string // source text of Node
+	Value Value  // result, Null when Err is set or the node is skipped
+	Err   error  // error raised by this node or one of its operands
+
+	// ShortCircuit tells that an and/or node was decided by its left operand
+	// so its right operand was not evaluated
+	ShortCircuit bool
+
+	// Skipped tells that the node was not evaluated, because of short circuit
+	// or because a previous operand failed
+	Skipped bool
+
+	Operands []*Trace
+}
+
+// String formats the trace as an indented tree, one sub expression per line
+func (t *Trace) String() string {
+	var sb strings.Builder
+	t.write(&sb, 0)
+	return sb.String()
+}
+
+func (t *Trace) write(sb *strings.Builder, indent int) {
+	sb.WriteString(strings.Repeat("  ", indent))
+	sb.WriteString(t.Expr)
+	sb.WriteString(" => ")
+	switch {
+	case t.Skipped:
+		sb.WriteString("skipped")
+	case t.Err != nil:
+		sb.WriteString("error: " + t.Err.Error())
+	default:
+		sb.WriteString(t.Value.String())
+	}
+	if t.ShortCircuit {
+		sb.WriteString(" (short circuit)")
+	}
+	sb.WriteByte('\n')
+	for _, o := range t.Operands {
+		o.write(sb, indent+1)
+	}
+}
+
+// Explain evaluates the program against env like Program.Eval and records
+// the value of every sub expression. The returned error is the error of the
+// evaluation, the trace is always returned.
+func Explain(p *Program, env Resolver) (*Trace, error) {
+	e := &evaluator{env: env, limits: p.limits, clock: p.clock}
+	t := explain(p.root, e)
+	if t.Err != nil {
+		return t, t.Err
+	}
+	if t.Value.kind != KindBool {
+		t.Err = &TypeError{Op: "result", Kinds: []Kind{t.Value.kind}}
+		return t, t.Err
+	}
+	return t, nil
+}
+
+func explain(n Node, e *evaluator) *Trace {
+	t := &Trace{Node: n, Expr: format(n)}
+	if t.Err = e.step(1); t.Err != nil {
+		return t
+	}
+
+	// operands evaluates the given nodes in order, nodes after a failed one
+	// are skipped
+	operands := func(nodes ...Node) []Value {
+		vals := make([]Value, len(nodes))
+		for i, o := range nodes {
+			if t.Err != nil {
+				t.Operands = append(t.Operands, &Trace{Node: o, Expr: format(o), Skipped: true})
+				continue
+			}
+			ot := explain(o, e)
+			t.Operands = append(t.Operands, ot)
+			t.Err, vals[i] = ot.Err, ot.Value
+		}
+		return vals
+	}
+
+	switch n := n.(type) {
+	case *Literal:
+		t.Value = n.Value
+	case *Ident:
+		t.Value, t.Err = e.resolve(n.Name)
+	case *ListExpr:
+		vals := operands(n.Elems...)
+		if t.Err == nil {
+			t.Value = List(vals...)
+		}
+	case *CallExpr:
+		args := operands(n.Args...)
+		if t.Err != nil {
+			break
+		}
+		fn, ok := LookupFunc(n.Func)
+		if !ok {
+			t.Err = &UndefinedFuncError{Name: n.Func}
+			break
+		}
+		if t.Err = fn.checkArity(len(args)); t.Err == nil {
+			t.Value, t.Err = invoke(fn, args, e)
+		}
+	case *UnaryExpr:
+		x := operands(n.X)[0]
+		if t.Err == nil {
+			t.Value, t.Err = unary(n.Op, x)
+		}
+	case *BinaryExpr:
+		if n.Op == OpAnd || n.Op == OpOr {
+			x := operands(n.X)[0]
+			if t.Err != nil {
+				t.Operands = append(t.Operands, &Trace{Node: n.Y, Expr: format(n.Y), Skipped: true})
+				break
+			}
+			if x.kind != KindBool {
+				t.Err = &TypeError{Op: n.Op.String(), Kinds: []Kind{x.kind}}
+				t.Operands = append(t.Operands, &Trace{Node: n.Y, Expr: format(n.Y), Skipped: true})
+				break
+			}
+			if x.b == (n.Op == OpOr) {
+				t.Value, t.ShortCircuit = x, true
+				t.Operands = append(t.Operands, &Trace{Node: n.Y, Expr: format(n.Y), Skipped: true})
+				break
+			}
+			y := operands(n.Y)[0]
+			if t.Err != nil {
+				break
+			}
+			if y.kind != KindBool {
+				t.Err = &TypeError{Op: n.Op.String(), Kinds: []Kind{x.kind, y.kind}}
+				break
+			}
+			t.Value = y
+			break
+		}
+		vals := operands(n.X, n.Y)
+		if t.Err != nil {
+			break
+		}
+		switch {
+		case n.Op == OpIn && vals[1].kind == KindList:
+			t.Err = e.step(vals[1].Len())
+		case n.Op == OpContains && vals[0].kind == KindList:
+			t.Err = e.step(vals[0].Len())
+		}
+		if t.Err == nil {
+			t.Value, t.Err = binary(n.Op, vals[0], vals[1])
+		}
+	case *BetweenExpr:
+		vals := operands(n.X, n.Low, n.High)
+		if t.Err == nil {
+			t.Value, t.Err = between(vals[0], vals[1], vals[2])
+		}
+	}
+	if t.Err != nil {
+		t.Value = Null
+	}
+	return t
+}
+
+// Analysis lists what an expression references
+type Analysis struct {
+	Variables []string // sorted names of the variables, e.g: user.country
+	Functions []string // sorted names of the called functions
+}
+
+// Analyze parses src and lists the variables and functions it references
+// without evaluating it. Functions don't need to be registered, so rules can
+// be validated against a schema before saving.
+func Analyze(src string) (*Analysis, error) {
+	root, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	vars, funcs := map[string]bool{}, map[string]bool{}
+	var walk func(n Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case *Ident:
+			vars[n.Name] = true
+		case *ListExpr:
+			for _, e := range n.Elems {
+				walk(e)
+			}
+		case *CallExpr:
+			funcs[n.Func] = true
+			for _, a := range n.Args {
+				walk(a)
+			}
+		case *UnaryExpr:
+			walk(n.X)
+		case *BinaryExpr:
+			walk(n.X)
+			walk(n.Y)
+		case *BetweenExpr:
+			walk(n.X)
+			walk(n.Low)
+			walk(n.High)
+		}
+	}
+	walk(root)
+	return &Analysis{Variables: sortedKeys(vars), Functions: sortedKeys(funcs)}, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
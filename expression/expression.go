@@ -1,56 +1,290 @@
 package expression
 
 import (
-	"errors"
-	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
-	"reflect"
+	"regexp"
 	"strings"
+
+	"github.com/subiz/goutils/clock"
 )
 
+// Resolver gives expressions the value of variables. Resolve returns false
+// when the variable is not defined.
+type Resolver interface {
+	Resolve(name string) (any, bool)
+}
+
+// ResolverFunc adapts a function to the Resolver interface
+type ResolverFunc func(name string) (any, bool)
+
+func (f ResolverFunc) Resolve(name string) (any, bool) { return f(name) }
+
+// Map is a Resolver backed by a map. Dotted names are looked up as is first,
+// then by walking nested maps, e.g: user.country resolves m["user.country"]
+// or m["user"]["country"]
+type Map map[string]any
+
+func (m Map) Resolve(name string) (any, bool) {
+	if v, ok := m[name]; ok {
+		return v, true
+	}
+
+	var cur any = map[string]any(m)
+	for name != "" {
+		key := name
+		rest := ""
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			key, rest = name[:i], name[i+1:]
+		}
+		switch c := cur.(type) {
+		case map[string]any:
+			v, ok := c[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case Map:
+			v, ok := c[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case map[string]string:
+			v, ok := c[key]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		default:
+			return nil, false
+		}
+		name = rest
+	}
+	return cur, true
+}
+
+// ParseAndEval evaluates an expression without variables, e.g:
+// (true OR false) AND false
 func ParseAndEval(exp string) (bool, error) {
-	exp = strings.ToLower(exp)
-	exp = strings.Replace(exp, "and", "&&", -1)
-	exp = strings.Replace(exp, "or", "||", -1)
-	tree, err := parser.ParseExpr(exp)
+	return Eval(exp, nil)
+}
+
+// Eval evaluates a boolean expression against the variables of env, e.g:
+// user.country == "VN" and conversation.channel in ["email", "fb"]
+// env may be nil when the expression doesn't use any variable. Use Compile
+// for expressions evaluated many times.
+func Eval(exp string, env Resolver) (bool, error) {
+	p, err := Compile(exp)
 	if err != nil {
 		return false, err
 	}
-	return eval(tree)
+	return p.Eval(env)
+}
+
+func resolve(name string, env Resolver) (Value, error) {
+	if env == nil {
+		return Null, &UndefinedError{Name: name}
+	}
+	i, ok := env.Resolve(name)
+	if !ok {
+		return Null, &UndefinedError{Name: name}
+	}
+	v, ok := ValueOf(i)
+	if !ok {
+		return Null, &ValueError{Name: name, Value: i}
+	}
+	return v, nil
+}
+
+func unary(op Op, x Value) (Value, error) {
+	switch {
+	case op == OpNot && x.kind == KindBool:
+		return Bool(!x.b), nil
+	case op == OpNeg && x.kind == KindNumber:
+		return Number(-x.n), nil
+	case op == OpNeg && x.kind == KindDuration:
+		return Value{kind: KindDuration, i: -x.i}, nil
+	}
+	return Null, &TypeError{Op: op.String(), Kinds: []Kind{x.kind}}
+}
+
+func binary(op Op, x, y Value) (Value, error) {
+	switch op {
+	case OpEq, OpNe:
+		// anything can be compared to null
+		if x.kind == y.kind || x.kind == KindNull || y.kind == KindNull {
+			return Bool(equal(x, y) == (op == OpEq)), nil
+		}
+		// timestamps given as numbers equal times
+		if c, ok := compare(x, y); ok {
+			return Bool((c == 0) == (op == OpEq)), nil
+		}
+	case OpLt, OpLe, OpGt, OpGe:
+		c, ok := compare(x, y)
+		if !ok {
+			break
+		}
+		switch op {
+		case OpLt:
+			return Bool(c < 0), nil
+		case OpLe:
+			return Bool(c <= 0), nil
+		case OpGt:
+			return Bool(c > 0), nil
+		}
+		return Bool(c >= 0), nil
+	case OpAdd, OpSub:
+		if v, ok := arith(op, x, y); ok {
+			return v, nil
+		}
+	case OpIn:
+		if ok, valid := contains(y, x); valid {
+			return Bool(ok), nil
+		}
+	case OpContains:
+		if ok, valid := contains(x, y); valid {
+			return Bool(ok), nil
+		}
+	case OpStartsWith:
+		if x.kind == KindString && y.kind == KindString {
+			return Bool(strings.HasPrefix(x.s, y.s)), nil
+		}
+	case OpEndsWith:
+		if x.kind == KindString && y.kind == KindString {
+			return Bool(strings.HasSuffix(x.s, y.s)), nil
+		}
+	case OpMatches:
+		if x.kind == KindString && y.kind == KindString {
+			re, err := compileRegex(y.s)
+			if err != nil {
+				return Null, err
+			}
+			return Bool(re.MatchString(x.s)), nil
+		}
+	}
+	return Null, &TypeError{Op: op.String(), Kinds: []Kind{x.kind, y.kind}}
+}
+
+// between tells whether x is in the closed range from low to high
+func between(x, low, high Value) (Value, error) {
+	c1, ok1 := compare(x, low)
+	c2, ok2 := compare(x, high)
+	if !ok1 || !ok2 {
+		return Null, &TypeError{Op: OpBetween.String(), Kinds: []Kind{x.kind, low.kind, high.kind}}
+	}
+	return Bool(c1 >= 0 && c2 <= 0), nil
+}
+
+// timestamp returns the unix nanoseconds of a time or of a number holding a
+// timestamp in seconds, milliseconds, microseconds or nanoseconds, see
+// clock.Guess
+func timestamp(v Value) (int64, bool) {
+	switch v.kind {
+	case KindTime:
+		return v.i, true
+	case KindNumber:
+		return clock.Guess(int64(v.n)).UnixNano(), true
+	}
+	return 0, false
+}
+
+// compare orders x and y, ok is false when they cannot be ordered. Numbers
+// are compared to times as timestamps.
+func compare(x, y Value) (c int, ok bool) {
+	switch {
+	case x.kind == KindNumber && y.kind == KindNumber:
+		return compareNumber(x.n, y.n), true
+	case x.kind == KindString && y.kind == KindString:
+		return strings.Compare(x.s, y.s), true
+	case x.kind == KindDuration && y.kind == KindDuration:
+		return compareInt(x.i, y.i), true
+	case x.kind == KindTime || y.kind == KindTime:
+		a, aok := timestamp(x)
+		b, bok := timestamp(y)
+		if aok && bok {
+			return compareInt(a, b), true
+		}
+	}
+	return 0, false
 }
 
-func eval(tree ast.Expr) (bool, error) {
-	switch n := tree.(type) {
-	case *ast.Ident:
-		v := strings.ToLower(n.String())
-		if v != "true" && v != "false" {
-			return unsup(v)
+// arith adds or subtracts numbers, durations, and durations to times.
+// Numbers added to durations are timestamps.
+func arith(op Op, x, y Value) (Value, bool) {
+	sign := int64(1)
+	if op == OpSub {
+		sign = -1
+	}
+	switch {
+	case x.kind == KindNumber && y.kind == KindNumber:
+		return Number(x.n + float64(sign)*y.n), true
+	case x.kind == KindDuration && y.kind == KindDuration:
+		return Value{kind: KindDuration, i: x.i + sign*y.i}, true
+	case y.kind == KindDuration:
+		if t, ok := timestamp(x); ok {
+			return Value{kind: KindTime, i: t + sign*y.i}, true
 		}
-		return v == "true", nil
-	case *ast.BinaryExpr:
-		if n.Op != token.LOR && n.Op != token.LAND {
-			return unsup(n.Op)
+	case x.kind == KindDuration && op == OpAdd:
+		if t, ok := timestamp(y); ok {
+			return Value{kind: KindTime, i: t + x.i}, true
 		}
-		x, err := eval(n.X)
-		if err != nil {
-			return false, err
+	case op == OpSub && (x.kind == KindTime || y.kind == KindTime):
+		a, aok := timestamp(x)
+		b, bok := timestamp(y)
+		if aok && bok {
+			return Value{kind: KindDuration, i: a - b}, true
 		}
-		y, err := eval(n.Y)
-		if err != nil {
-			return false, err
+	}
+	return Null, false
+}
+
+func compareInt(a, b int64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareNumber(a, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+// contains tells whether list contains elem or string s contains substring
+// elem. valid is false when the kinds of the operands don't fit.
+func contains(s, elem Value) (ok, valid bool) {
+	switch s.kind {
+	case KindList:
+		n := s.Len()
+		for i := 0; i < n; i++ {
+			if equal(s.Index(i), elem) {
+				return true, true
+			}
 		}
-		if n.Op == token.LAND {
-			return x && y, nil
+		return false, true
+	case KindString:
+		if elem.kind != KindString {
+			return false, false
 		}
-		return x || y, nil
-	case *ast.ParenExpr:
-		return eval(n.X)
+		return strings.Contains(s.s, elem.s), true
 	}
-	return unsup(reflect.TypeOf(tree))
+	return false, false
 }
 
-func unsup(i interface{}) (bool, error) {
-	return false, errors.New(fmt.Sprintf("%v unsupported", i))
+// compileRegex compiles the pattern of matches operator, constant patterns
+// are compiled once by Compile, the others on every evaluation so patterns
+// coming from the environment aren't kept in memory
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &RegexError{Pattern: pattern, Err: err}
+	}
+	return re, nil
 }
@@ -0,0 +1,43 @@
+package expression
+
+import "testing"
+
+const benchRule = `user.country == "VN" and conversation.channel in ["email", "fb"] and user.age >= 18`
+
+var benchEnv = Map{
+	"user":                 map[string]any{"country": "VN", "age": 21},
+	"conversation.channel": "fb",
+}
+
+func BenchmarkEval(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Eval(benchRule, benchEnv); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramEval(b *testing.B) {
+	p := MustCompile(benchRule)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Eval(benchEnv); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgramEvalParallel(b *testing.B) {
+	p := MustCompile(benchRule)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := p.Eval(benchEnv); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
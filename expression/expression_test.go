@@ -1,10 +1,27 @@
 package expression
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/subiz/goutils/clock"
 )
 
+// evalAt is like Eval with the current time taken from clk
+func evalAt(exp string, env Resolver, clk clock.Clock) (bool, error) {
+	p, err := Compile(exp)
+	if err != nil {
+		return false, err
+	}
+	return p.WithClock(clk).Eval(env)
+}
+
 func TestParseAndEval(t *testing.T) {
 	var tests = []struct {
 		exp string
@@ -25,3 +42,924 @@ func TestParseAndEval(t *testing.T) {
 		}
 	}
 }
+
+func TestEval(t *testing.T) {
+	env := Map{
+		"user": map[string]any{
+			"country": "VN",
+			"age":     21,
+			"tags":    []string{"vip", "new"},
+		},
+		"conversation.channel": "email",
+		"score":                4.5,
+		"nick":                 nil,
+		"pattern":              "^e",
+	}
+
+	tcs := []struct {
+		exp string
+		res bool
+	}{
+		{`user.country == "VN" and conversation.channel in ["email", "fb"]`, true},
+		{`user.country == 'US' or conversation.channel in ["fb"]`, false},
+		{`user.age >= 18 && user.age < 30`, true},
+		{`user.age > 21`, false},
+		{`user.age <= 21`, true},
+		{`score != 4.5`, false},
+		{`score == -(-4.5)`, true},
+		{`not (user.country == "VN")`, false},
+		{`!false`, true},
+		{`NOT user.age == 21`, false},
+		{`user.country not in ["US", "SG"]`, true},
+		{`user.tags contains "vip"`, true},
+		{`user.tags contains "old"`, false},
+		{`"vip" in user.tags`, true},
+		{`"mail" in conversation.channel`, true},
+		{`conversation.channel contains "fb"`, false},
+		{`conversation.channel startsWith "em"`, true},
+		{`conversation.channel endsWith "ail"`, true},
+		{`conversation.channel matches "^e.*l$"`, true},
+		{`user.country matches "^v"`, false},
+		{`conversation.channel matches pattern`, true},
+		{`nick == null`, true},
+		{`user.age != null`, true},
+		{`"b" > "a"`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`1 in ["1", 2]`, false},
+		{`"And" == "and"`, false},
+	}
+	for _, tc := range tcs {
+		res, err := Eval(tc.exp, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.exp, err)
+			continue
+		}
+		if res != tc.res {
+			t.Errorf("%s: should be %v, got %v", tc.exp, tc.res, res)
+		}
+	}
+}
+
+func TestEvalError(t *testing.T) {
+	env := Map{"age": 21, "name": "van", "ch": make(chan int), "pattern": "("}
+
+	var syntaxErr *SyntaxError
+	var typeErr *TypeError
+	var undefinedErr *UndefinedError
+	var valueErr *ValueError
+	var regexErr *RegexError
+
+	tcs := []struct {
+		exp    string
+		target any
+	}{
+		{`age ==`, &syntaxErr},
+		{`(age == 1`, &syntaxErr},
+		{`age == "unterminated`, &syntaxErr},
+		{`1 < 2 < 3`, &syntaxErr},
+		{`age @ 1`, &syntaxErr},
+		{`age == "21"`, &typeErr},
+		{`name < 1`, &typeErr},
+		{`age and true`, &typeErr},
+		{`not name`, &typeErr},
+		{`name startsWith 1`, &typeErr},
+		{`age`, &typeErr},
+		{`missing == 1`, &undefinedErr},
+		{`ch == 1`, &valueErr},
+		{`name matches "("`, &regexErr},
+		{`name matches pattern`, &regexErr},
+	}
+	for _, tc := range tcs {
+		_, err := Eval(tc.exp, env)
+		if err == nil {
+			t.Errorf("%s: should fail", tc.exp)
+			continue
+		}
+		if !errors.As(err, tc.target) {
+			t.Errorf("%s: should be %T, got %T %v", tc.exp, tc.target, err, err)
+		}
+	}
+
+	// short circuit skips the invalid operand
+	if res, err := Eval(`false and missing`, env); err != nil || res {
+		t.Errorf("should short circuit, got %v %v", res, err)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	// errors detected without evaluating
+	tcs := []string{
+		`"a" < 1`,
+		`not "vip"`,
+		`1 + 1`,
+		`"abc" startsWith 1`,
+		`user.country == "VN" and 1`,
+		`[1, 2] contains 1 and -"a" == 1`,
+		`name matches "("`,
+		`"a"`,
+	}
+	for _, tc := range tcs {
+		if _, err := Compile(tc); err == nil {
+			t.Errorf("%s: should fail to compile", tc)
+		}
+	}
+
+	p := MustCompile(`user.country == "VN" and channel in ["email", "fb", fallback]`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			channel := "email"
+			if i%2 == 1 {
+				channel = "sms"
+			}
+			env := Map{"user": map[string]any{"country": "VN"}, "channel": channel, "fallback": "zalo"}
+			for j := 0; j < 100; j++ {
+				res, err := p.Eval(env)
+				if err != nil || res != (i%2 == 0) {
+					t.Errorf("%d: should be %v, got %v %v", i, i%2 == 0, res, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestProgramNoAlloc(t *testing.T) {
+	p := MustCompile(`user.country == "VN" and user.name matches "^v" and channel in ["email", "fb", fallback] and tags contains "vip" and not (age < 18) and len(tags) == 2 and len(user.name) == 3`)
+	env := Map{
+		"user":     map[string]any{"country": "VN", "name": "van"},
+		"channel":  "fb",
+		"fallback": "zalo",
+		"tags":     []string{"new", "vip"},
+		"age":      21,
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if res, err := p.Eval(env); err != nil || !res {
+			t.Fatalf("should be true, got %v %v", res, err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("should not allocate, got %v allocs", allocs)
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	env := Map{"brand": "Android", "order": 3, "ORDER_NOTE": "Fragile AND heavy", "notify": true}
+	tcs := []struct {
+		exp string
+		res bool
+	}{
+		{`brand == "Android" AND order > 2`, true},
+		{`brand == "android" or order == 1`, false},
+		{`ORDER_NOTE == "Fragile AND heavy"`, true},
+		{`ORDER_NOTE contains "AND"`, true},
+		{`notify And not (brand startsWith "and")`, true},
+		{"brand == 'Android'\n\tand\n\torder == 3", true},
+	}
+	for _, tc := range tcs {
+		res, err := Eval(tc.exp, env)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.exp, err)
+			continue
+		}
+		if res != tc.res {
+			t.Errorf("%s: should be %v, got %v", tc.exp, tc.res, res)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tcs := []struct {
+		exp      string
+		line     int
+		column   int
+		expected string
+		found    string
+	}{
+		{`(brand == "a"`, 1, 14, ")", "end of expression"},
+		{`brand == "a" and`, 1, 17, "operand", "end of expression"},
+		{"brand == \"a\"\nand order = 1", 2, 11, "", "'='"},
+		{"brand == \"a\"\n  and (order order)", 2, 14, ")", "identifier order"},
+		{`brand not "a"`, 1, 11, "in or between after not", `string "a"`},
+		{`brand in ["a" "b"]`, 1, 15, ", or ]", `string "b"`},
+		{`"Thành phố" == 'unterminated`, 1, 16, "'", ""},
+	}
+	for _, tc := range tcs {
+		_, err := Parse(tc.exp)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%s: should be *SyntaxError, got %v", tc.exp, err)
+			continue
+		}
+		if serr.Line != tc.line || serr.Column != tc.column {
+			t.Errorf("%s: should be at %d:%d, got %d:%d", tc.exp, tc.line, tc.column, serr.Line, serr.Column)
+		}
+		if serr.Expected != tc.expected || serr.Found != tc.found {
+			t.Errorf("%s: should expect %q found %q, got %q %q", tc.exp, tc.expected, tc.found, serr.Expected, serr.Found)
+		}
+	}
+}
+
+func TestFunc(t *testing.T) {
+	clk := clock.NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+
+	between := &Func{
+		Name:   "test.between",
+		Params: []Kind{KindNumber, KindNumber, KindNumber},
+		Result: KindBool,
+		Call: func(args []Value) (Value, error) {
+			return Bool(args[1].AsNumber() <= args[0].AsNumber() && args[0].AsNumber() <= args[2].AsNumber()), nil
+		},
+	}
+	if _, ok := LookupFunc(between.Name); !ok {
+		if err := Register(between); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Register(&Func{Name: "lower", Call: func([]Value) (Value, error) { return Null, nil }}); err == nil {
+		t.Errorf("should not register lower twice")
+	}
+	if err := Register(&Func{Name: "bad name", Call: func([]Value) (Value, error) { return Null, nil }}); err == nil {
+		t.Errorf("should not register invalid name")
+	}
+	if err := Register(&Func{Name: "test.optional", Params: []Kind{KindString}, Optional: 2, Call: func([]Value) (Value, error) { return Null, nil }}); err == nil {
+		t.Errorf("should not register more optional parameters than parameters")
+	}
+	var arityErr *CallError
+	for _, exp := range []string{`hourOfDay(now(), "+07:00", "UTC") == 1`, `dayOfWeek() == "Monday"`, `tzOffset() == ""`} {
+		if _, err := Compile(exp); !errors.As(err, &arityErr) {
+			t.Errorf("%s: should fail to compile with *CallError, got %v", exp, err)
+		}
+	}
+
+	env := Map{
+		"user":    map[string]any{"name": "Thành", "tags": []string{"a", "b"}, "created": int64(1709640000000)},
+		"nothing": nil,
+	}
+	tcs := []struct {
+		exp string
+		res bool
+	}{
+		{`lower(user.name) == "thành"`, true},
+		{`upper("vip") == "VIP"`, true},
+		{`len(user.name) == 5`, true},
+		{`len(user.tags) == 2 and len(nothing) == 0`, true},
+		{`now() == 1710072000000`, true},
+		{`daysSince(user.created) == 5`, true},
+		{`daysSince(1710072000) == 0`, true},
+		{`tzOffset("Asia/Ho_Chi_Minh") == "+07:00"`, true},
+		{`test.between(daysSince(user.created), 1, 7)`, true},
+		{`test.between(len(user.tags), 3, 7)`, false},
+		{`daysSince(2024-03-01) == 9`, true},
+		{`dayOfWeek(2024-03-10T23:00:00Z) == "Sunday"`, true},
+		{`dayOfWeek(2024-03-10T23:00:00Z, "+07:00") == "Monday"`, true},
+		{`dayOfWeek(user.created, "Asia/Ho_Chi_Minh") == "Tuesday"`, true},
+		{`hourOfDay(now()) == 12 and hourOfDay(now(), "-05:30") == 6`, true},
+		{`hourOfDay(1710072000, "Asia/Ho_Chi_Minh") == 19`, true},
+		{`hourOfDay(2024-01-01T12:00:00Z, "America/New_York") == 7`, true},
+		{`hourOfDay(2024-07-01T12:00:00Z, "America/New_York") == 8`, true},
+		{`tzOffset("America/New_York") == "-04:00"`, true},
+	}
+	for _, tc := range tcs {
+		res, err := evalAt(tc.exp, env, clk)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.exp, err)
+			continue
+		}
+		if res != tc.res {
+			t.Errorf("%s: should be %v, got %v", tc.exp, tc.res, res)
+		}
+	}
+
+	var callErr *CallError
+	var undefinedErr *UndefinedFuncError
+	var typeErr *TypeError
+	errs := []struct {
+		exp    string
+		target any
+	}{
+		{`lower("A", "B") == "a"`, &callErr},
+		{`now(1) > 0`, &callErr},
+		{`hourOfDay(now(), "+07:00", "UTC") == 1`, &callErr},
+		{`hourOfDay(now(), "7h") == 1`, &callErr},
+		{`dayOfWeek("monday") == "Monday"`, &callErr},
+		{`missing(1)`, &undefinedErr},
+		{`lower(1) == "1"`, &typeErr},
+		{`lower(user.tags) == "a"`, &typeErr},
+		{`len(true) == 1`, &typeErr},
+		{`lower("A") > 1`, &typeErr},
+	}
+	for _, tc := range errs {
+		_, err := Eval(tc.exp, env)
+		if !errors.As(err, tc.target) {
+			t.Errorf("%s: should be %T, got %v", tc.exp, tc.target, err)
+		}
+	}
+
+	// the current time is read from the clock of the program, once per
+	// evaluation
+	if _, ok := LookupFunc("test.tick"); !ok {
+		tick := &Func{
+			Name:   "test.tick",
+			Result: KindTime,
+			CallNow: func(now time.Time, args []Value) (Value, error) {
+				clk.Advance(time.Hour)
+				return Time(now), nil
+			},
+		}
+		if err := Register(tick); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := MustCompile(`test.tick() == 2024-03-10T12:00:00Z and now() == 2024-03-10T12:00:00Z`)
+	if ok, err := p.WithClock(clk).Eval(env); err != nil || !ok {
+		t.Errorf("should read the fake clock once, got %v %v", ok, err)
+	}
+	if ok, err := p.WithClock(clk).Eval(env); err != nil || ok {
+		t.Errorf("should read the advanced fake clock, got %v %v", ok, err)
+	}
+	if ok, err := p.Eval(env); err != nil || ok {
+		t.Errorf("should default to the real clock, got %v %v", ok, err)
+	}
+
+	p = MustCompile(`len(user.tags) == 2 and daysSince(user.created) < 7`)
+	allocs := testing.AllocsPerRun(100, func() { p.Eval(env) })
+	if allocs != 0 {
+		t.Errorf("should not allocate, got %v allocs", allocs)
+	}
+}
+
+func TestTime(t *testing.T) {
+	clk := clock.NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+
+	created := time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC)
+	env := Map{
+		"createdAt": created,
+		"sec":       created.Unix(),
+		"milli":     created.UnixMilli(),
+		"nano":      created.UnixNano(),
+		"lastSeen":  clk.Now().Add(-36 * time.Hour).UnixMilli(),
+		"timeout":   90 * time.Second,
+	}
+	tcs := []struct {
+		exp string
+		res bool
+	}{
+		{`createdAt between 2024-01-01 and 2024-02-01`, true},
+		{`createdAt between 2024-01-16 and 2024-02-01`, false},
+		{`createdAt not between 2024-01-16 and 2024-02-01`, true},
+		{`createdAt between 2024-01-15T08:30:00Z and 2024-01-15T08:30:00Z`, true},
+		{`createdAt == 2024-01-15T15:30:00+07:00`, true},
+		{`createdAt > 2024-01-15T08:29:59.999Z`, true},
+		{`createdAt < 2024-01-15T08:30`, false},
+		{`sec == createdAt and milli == createdAt and nano == createdAt`, true},
+		{`sec between 2024-01-01 and 2024-02-01`, true},
+		{`createdAt - sec == 0s and nano - createdAt == 0s`, true},
+		{`lastSeen > now() - 7d`, true},
+		{`lastSeen > now() - 1d`, false},
+		{`lastSeen + 36h == now()`, true},
+		{`createdAt + 1w - 1d == 2024-01-21T08:30:00Z`, true},
+		{`now() - createdAt > 50d and now() - createdAt < 60d`, true},
+		{`now() - 2024-03-09 == 1d12h`, true},
+		{`timeout == 1m30s and timeout < 2m and timeout > 1.5m - 1ns`, true},
+		{`timeout between 30s and 5m`, true},
+		{`9223372036854775807ns > 2562047h and 9223372036854775807ns - 1ns == 9223372036854775806ns`, true},
+		{`-timeout < 0s`, true},
+		{`1h - 90m == -30m`, true},
+		{`1 + 2 - 3 == 0 and 10 - 2 - 3 == 5`, true},
+		{`1 + 2 between 3 and 4`, true},
+		{`"b" between "a" and "c"`, true},
+		{`createdAt != null and null != 1s`, true},
+	}
+	for _, tc := range tcs {
+		res, err := evalAt(tc.exp, env, clk)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.exp, err)
+			continue
+		}
+		if res != tc.res {
+			t.Errorf("%s: should be %v, got %v", tc.exp, tc.res, res)
+		}
+	}
+
+	var typeErr *TypeError
+	var syntaxErr *SyntaxError
+	errs := []struct {
+		exp    string
+		target any
+	}{
+		{`createdAt + createdAt > 0`, &typeErr},
+		{`1s - createdAt == 1s`, &typeErr},
+		{`1s == 1`, &typeErr},
+		{`"a" between 1 and 2`, &typeErr},
+		{`timeout + "s" == 1`, &typeErr},
+		{`a between 1`, &syntaxErr},
+		{`a between 1 and 2 between 3 and 4`, &syntaxErr},
+		{`a > 7x`, &syntaxErr},
+		{`a > 2024-13-01`, &syntaxErr},
+		{`a > 2024-01-01T25:00`, &syntaxErr},
+		{`a > 99999999999d`, &syntaxErr},
+		{`a > 9223372036854775808ns`, &syntaxErr},
+		{`a > 9223372036854775807ns1ns`, &syntaxErr},
+		{`a > 9223372036.854775808s`, &syntaxErr},
+	}
+	for _, tc := range errs {
+		_, err := Eval(tc.exp, env)
+		if !errors.As(err, tc.target) {
+			t.Errorf("%s: should be %T, got %v", tc.exp, tc.target, err)
+		}
+	}
+
+	// timestamps of unknown kind are only checked while evaluating
+	if _, err := Eval(`createdAt - 1d > 0`, Map{"createdAt": "yesterday"}); !errors.As(err, &typeErr) {
+		t.Errorf("should be *TypeError, got %v", err)
+	}
+	if _, err := Compile(`now() + 1d > 1s`); !errors.As(err, &typeErr) {
+		t.Errorf("should reject comparing times to durations, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{MaxDepth: 10, MaxNodes: 25, MaxStringLen: 8, MaxSteps: 50}
+	env := Map{"long": strings.Repeat("a", 9), "short": "a", "big": make([]int, 100)}
+
+	var depthErr *DepthLimitError
+	var nodeErr *NodeLimitError
+	var stringErr *StringLimitError
+	var stepErr *StepLimitError
+	tcs := []struct {
+		exp    string
+		target any
+	}{
+		{strings.Repeat("(", 11) + "true" + strings.Repeat(")", 11), &depthErr},
+		{strings.Repeat("not ", 20) + "true", &depthErr},
+		{strings.Repeat("true and (", 10) + "true" + strings.Repeat(")", 10), &depthErr},
+		{"short in [" + strings.Repeat("'a', ", 30) + "'b']", &nodeErr},
+		{`short == "aaaaaaaaa"`, &stringErr},
+		{`long == "a"`, &stringErr},
+		{`1 in big`, &stepErr},
+	}
+	for _, tc := range tcs {
+		p, err := CompileWithLimits(tc.exp, limits)
+		if err == nil {
+			_, err = p.Eval(env)
+		}
+		if tc.target == nil {
+			continue
+		}
+		if !errors.As(err, tc.target) {
+			t.Errorf("%s: should be %T, got %v", tc.exp, tc.target, err)
+		}
+	}
+
+	// within limits
+	p := MustCompile(`short in ["a", "b"] and not (len(big) > 100)`)
+	if res, err := p.Eval(env); err != nil || !res {
+		t.Errorf("should be true, got %v %v", res, err)
+	}
+
+	// a long flat chain is a single level deep
+	chain := strings.Repeat("false or ", 300) + "true"
+	if res, err := ParseAndEval(chain); err != nil || !res {
+		t.Errorf("should be true, got %v %v", res, err)
+	}
+	chain = strings.Repeat("short == 'x' or ", 200) + "short == 'a'"
+	if res, err := MustCompile(chain).Eval(env); err != nil || !res {
+		t.Errorf("should be true, got %v %v", res, err)
+	}
+	n, err := Parse(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unmarshal(b); err != nil {
+		t.Errorf("should unmarshal a long chain, got %v", err)
+	}
+
+	// deep nesting doesn't overflow the stack
+	if _, err := Parse(strings.Repeat("(", 1e6)); !errors.As(err, &depthErr) {
+		t.Errorf("should be *DepthLimitError, got %v", err)
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	p := MustCompile(`1 in big`)
+	env := Map{"big": make([]int, 1000)}
+	ctx, cancel := context.WithCancel(context.Background())
+	if res, err := p.EvalContext(ctx, env); err != nil || res {
+		t.Errorf("should be false, got %v %v", res, err)
+	}
+	cancel()
+	_, err := p.EvalContext(ctx, env)
+	var canceledErr *CanceledError
+	if !errors.As(err, &canceledErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("should be canceled, got %v", err)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	p := MustCompile(`user.country == "VN" and (channel in ["email", "fb"] or lower(channel) startsWith "z")`)
+	trace, err := Explain(p, Map{"user": map[string]any{"country": "VN"}, "channel": "email"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `user.country == "VN" and (channel in ["email", "fb"] or lower(channel) startsWith "z") => true
+  user.country == "VN" => true
+    user.country => "VN"
+    "VN" => "VN"
+  channel in ["email", "fb"] or lower(channel) startsWith "z" => true (short circuit)
+    channel in ["email", "fb"] => true
+      channel => "email"
+      ["email", "fb"] => ["email", "fb"]
+        "email" => "email"
+        "fb" => "fb"
+    lower(channel) startsWith "z" => skipped
+`
+	if trace.String() != expected {
+		t.Errorf("should be\n%s\ngot\n%s", expected, trace)
+	}
+
+	trace, err = Explain(p, Map{"user": map[string]any{"country": "US"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := trace.Value; v.Kind() != KindBool || v.AsBool() || !trace.ShortCircuit || !trace.Operands[1].Skipped {
+		t.Errorf("should short circuit to false, got\n%s", trace)
+	}
+
+	trace, err = Explain(p, Map{"user": map[string]any{"country": "VN"}, "channel": 1})
+	var typeErr *TypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("should be *TypeError, got %v", err)
+	}
+	if failed := trace.Operands[1].Operands[1]; failed.Expr != `lower(channel) startsWith "z"` || failed.Err == nil {
+		t.Errorf("should report the failed sub expression, got\n%s", trace)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	a, err := Analyze(`user.country == "VN" and not custom.vip(user.id, lower(user.country)) or user.id in [bot.id, 1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := []string{"bot.id", "user.country", "user.id"}
+	funcs := []string{"custom.vip", "lower"}
+	if fmt.Sprint(a.Variables) != fmt.Sprint(vars) {
+		t.Errorf("should be %v, got %v", vars, a.Variables)
+	}
+	if fmt.Sprint(a.Functions) != fmt.Sprint(funcs) {
+		t.Errorf("should be %v, got %v", funcs, a.Functions)
+	}
+	if _, err := Analyze(`user.country ==`); err == nil {
+		t.Errorf("should fail")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tcs := []struct {
+		src, formatted string
+	}{
+		{`(true OR false) AND false`, `(true or false) and false`},
+		{`true || false && !false`, `true or false and not false`},
+		{`a and (b and c)`, `a and (b and c)`},
+		{`(a and b) and c`, `a and b and c`},
+		{`not (a == 1)`, `not a == 1`},
+		{`x not in ['a', "b"]`, `not x in ["a", "b"]`},
+		{`(a == b) == c`, `(a == b) == c`},
+		{`-x == -1`, `-x == -1`},
+		{`-(-x) < - 2.50`, `-(-x) < -2.5`},
+		{`lower( name )startsWith'it\'s "q"'`, `lower(name) startsWith "it's \"q\""`},
+		{"note contains \"a\\tb\\u0001\"", `note contains "a\tb\u0001"`},
+		{`len([]) == 1e21`, `len([]) == 1e+21`},
+		{`NOT (NOT a) OR null != b`, `not not a or null != b`},
+		{`a - (b - c) == a - b + c`, `a - (b - c) == a - b + c`},
+		{`-(a + 1h30m) < -1d`, `-(a + 1h30m) < -1d`},
+		{`x NOT BETWEEN 2024-01-01T00:00 AND 2024-01-02T10:00:00+07:00`, `not x between 2024-01-01 and 2024-01-02T03:00:00Z`},
+		{`(a between 1 and 2) == (b between 1 + 1 and 3)`, `(a between 1 and 2) == (b between 1 + 1 and 3)`},
+		{`t > 2024-01-01T10:00:00.5Z - -90s`, `t > 2024-01-01T10:00:00.5Z - -1m30s`},
+	}
+	for _, tc := range tcs {
+		n, err := Parse(tc.src)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.src, err)
+		}
+		out := Format(n)
+		if out != tc.formatted {
+			t.Errorf("%s: should be %s, got %s", tc.src, tc.formatted, out)
+		}
+		// formatting is stable
+		n2, err := Parse(out)
+		if err != nil {
+			t.Fatalf("%s: %v", out, err)
+		}
+		if Format(n2) != out {
+			t.Errorf("%s: should be stable, got %s", out, Format(n2))
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	n, err := Parse(`user.country == "VN" and not lower(channel) in ["email", null, -1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"binary","op":"and",` +
+		`"x":{"type":"binary","op":"==","x":{"type":"ident","name":"user.country"},"y":{"type":"literal","value":"VN"}},` +
+		`"y":{"type":"unary","op":"not","x":{"type":"binary","op":"in",` +
+		`"x":{"type":"call","func":"lower","args":[{"type":"ident","name":"channel"}]},` +
+		`"y":{"type":"list","elems":[{"type":"literal","value":"email"},{"type":"literal","value":null},{"type":"literal","value":-1}]}}}}`
+	if string(b) != expected {
+		t.Errorf("should be\n%s\ngot\n%s", expected, b)
+	}
+
+	n2, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Format(n2) != Format(n) {
+		t.Errorf("should round trip, got %s", Format(n2))
+	}
+
+	// constant lists of compiled programs are literals
+	b, err = Marshal(&Literal{Value: List(String("a"), Number(1))})
+	if err != nil || string(b) != `{"type":"list","elems":[{"type":"literal","value":"a"},{"type":"literal","value":1}]}` {
+		t.Errorf("should marshal list literal, got %s %v", b, err)
+	}
+
+	for _, exp := range []string{`-x > 1`, `not -(a - b) < 0`} {
+		n, err := Parse(exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n2, err := Unmarshal(b); err != nil || Format(n2) != exp {
+			t.Errorf("%s: should round trip, got %v %v", exp, n2, err)
+		}
+	}
+
+	n, err = Parse(`createdAt between 2024-01-01 and now() - 7d12h`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = Marshal(n)
+	expected = `{"type":"between","x":{"type":"ident","name":"createdAt"},` +
+		`"low":{"type":"literal","kind":"time","value":"2024-01-01"},` +
+		`"high":{"type":"binary","op":"-","x":{"type":"call","func":"now"},"y":{"type":"literal","kind":"duration","value":"7d12h"}}}`
+	if err != nil || string(b) != expected {
+		t.Errorf("should be\n%s\ngot\n%s %v", expected, b, err)
+	}
+	if n2, err = Unmarshal(b); err != nil || Format(n2) != Format(n) {
+		t.Errorf("should round trip, got %v %v", n2, err)
+	}
+
+	invalid := []string{
+		`{"type":"literal","kind":"time","value":"7d"}`,
+		`{"type":"literal","kind":"duration","value":1}`,
+		`{"type":"literal","kind":"object","value":"a"}`,
+		`{"type":"between","x":{"type":"literal","value":1}}`,
+		`{"type":"ident","name":"and"}`,
+		`{"type":"ident","name":"a b"}`,
+		`{"type":"literal","value":{"a":1}}`,
+		`{"type":"unary","op":"==","x":{"type":"literal","value":1}}`,
+		`{"type":"binary","op":"not","x":{"type":"literal","value":1},"y":{"type":"literal","value":1}}`,
+		`{"type":"binary","op":"=="}`,
+		`{"type":"call","func":"1abc"}`,
+		`{"type":"lambda"}`,
+		`[]`,
+	}
+	for _, tc := range invalid {
+		if _, err := Unmarshal([]byte(tc)); err == nil {
+			t.Errorf("%s: should fail", tc)
+		}
+	}
+
+	deep := strings.Repeat(`{"type":"unary","op":"not","x":`, 100) + `{"type":"literal","value":true}` + strings.Repeat("}", 100)
+	var depthErr *DepthLimitError
+	if _, err := Unmarshal([]byte(deep)); !errors.As(err, &depthErr) {
+		t.Errorf("should be *DepthLimitError, got %v", err)
+	}
+
+	long := `{"type":"literal","value":"` + strings.Repeat("a", DefaultLimits.MaxStringLen+1) + `"}`
+	var stringErr *StringLimitError
+	if _, err := Unmarshal([]byte(long)); !errors.As(err, &stringErr) {
+		t.Errorf("should be *StringLimitError, got %v", err)
+	}
+}
+
+func TestSQLTranslator(t *testing.T) {
+	tcs := []struct {
+		exp   string
+		where string
+		args  []any
+	}{
+		{`user.country == "VN" and channel in ["email", "fb"]`, `user.country = ? AND channel IN (?, ?)`, []any{"VN", "email", "fb"}},
+		{`a > 1 or b <= 2 and not c != "x"`, `a > ? OR (b <= ? AND NOT ((c <> ? OR c IS NULL)))`, []any{1.0, 2.0, "x"}},
+		{`(a or b) and c`, `(a OR b) AND c`, nil},
+		{`deleted == null and null != owner`, `deleted IS NULL AND owner IS NOT NULL`, nil},
+		{`lower(name) startsWith "50%_off!"`, `LOWER(name) LIKE ? ESCAPE '!'`, []any{"50!%!_off!!%"}},
+		{`name endsWith "vn" or "mail" in email or tags contains "vip"`, `name LIKE ? ESCAPE '!' OR email LIKE ? ESCAPE '!' OR tags LIKE ? ESCAPE '!'`, []any{"%vn", "%mail%", "%vip%"}},
+		{`["a", "b"] contains kind and kind not in []`, `kind IN (?, ?) AND NOT (FALSE)`, []any{"a", "b"}},
+		{`vip == true and len(name) > 3`, `vip = TRUE AND LENGTH(name) > ?`, []any{3.0}},
+		{`created between 2024-01-01 and 2024-02-01T10:00:00Z`, `created BETWEEN ? AND ?`,
+			[]any{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)}},
+		{`a - (b + 1) > 2 - c`, `a - (b + ?) > ? - c`, []any{1.0, 2.0}},
+		{`(a or b) == true`, `(a OR b) = TRUE`, nil},
+		{`(a == 1) == (b == 2)`, `(a = ?) = (b = ?)`, []any{1.0, 2.0}},
+		{`(x in ["a"]) != (y startsWith "b")`, `(x IN (?)) <> (y LIKE ? ESCAPE '!')`, []any{"a", "b%"}},
+		{`not (x == "a") and x not in ["a", "b"] and not (["c"] contains y)`, `(x <> ? OR x IS NULL) AND (NOT (x IN (?, ?)) OR x IS NULL) AND (NOT (y IN (?)) OR y IS NULL)`, []any{"a", "a", "b", "c"}},
+		{`not (x == null)`, `NOT (x IS NULL)`, nil},
+		{`a != b or len(c) != 1`, `(a <> b OR a IS NULL AND b IS NOT NULL OR a IS NOT NULL AND b IS NULL) OR LENGTH(c) <> ?`, []any{1.0}},
+	}
+	for _, tc := range tcs {
+		sql, err := (&SQLTranslator{}).Where(MustCompile(tc.exp))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.exp, err)
+			continue
+		}
+		if sql.Where != tc.where || fmt.Sprint(sql.Args) != fmt.Sprint(tc.args) {
+			t.Errorf("%s: should be %s %v, got %s %v", tc.exp, tc.where, tc.args, sql.Where, sql.Args)
+		}
+	}
+
+	pg := &SQLTranslator{
+		Columns:     map[string]string{"user.country": "u.country", "channel": "c.channel"},
+		Placeholder: func(i int) string { return "$" + strconv.Itoa(i) },
+	}
+	out, err := pg.Translate(MustCompile(`user.country == "VN" and channel in ["email", "fb"]`))
+	if sql := out.(*SQL); err != nil || sql.Where != `u.country = $1 AND c.channel IN ($2, $3)` {
+		t.Errorf("should use columns and placeholders, got %v %v", out, err)
+	}
+
+	var unsupportedErr *UnsupportedError
+	unsupported := []string{
+		`name matches "^a"`,
+		`daysSince(created) > 3`,
+		`name in tags`,
+		`name contains prefix`,
+		`timeout > 1m`,
+	}
+	for _, tc := range unsupported {
+		_, err := (&SQLTranslator{}).Where(MustCompile(tc))
+		if !errors.As(err, &unsupportedErr) {
+			t.Errorf("%s: should be *UnsupportedError, got %v", tc, err)
+		}
+	}
+	if _, err := pg.Where(MustCompile(`secret == 1`)); !errors.As(err, &unsupportedErr) {
+		t.Errorf("should reject unknown columns, got %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	rows := []map[string]any{
+		{"id": 1, "user": map[string]any{"country": "VN"}, "channel": "email"},
+		{"id": 2, "user": map[string]any{"country": "US"}, "channel": "email"},
+		{"id": 3, "user": map[string]any{"country": "VN"}, "channel": "sms"},
+		{"id": 4, "channel": "fb"},
+	}
+	out, err := Filter(MustCompile(`(user.country == "VN" or user == null) and channel in ["email", "fb"]`), rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []any{}
+	for _, row := range out {
+		ids = append(ids, row["id"])
+	}
+	if fmt.Sprint(ids) != "[1 4]" {
+		t.Errorf("should be [1 4], got %v", ids)
+	}
+
+	pred, err := FilterTranslator{}.Translate(MustCompile(`id > 1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := pred.(func(map[string]any) (bool, error))(rows[1]); err != nil || !ok {
+		t.Errorf("should match, got %v %v", ok, err)
+	}
+
+	// SQL and Filter agree on null rows
+	nullRows := []map[string]any{{"c": nil}, {}, {"c": "x"}, {"c": "y"}}
+	for _, exp := range []string{`c != "x"`, `"x" != c`, `not (c == "x")`, `c not in ["x", "z"]`, `not (["x"] contains c)`} {
+		out, err := Filter(MustCompile(exp), nullRows)
+		if err != nil || len(out) != 3 {
+			t.Errorf("%s: should match all rows but c == x, got %v %v", exp, out, err)
+		}
+		sql, err := (&SQLTranslator{}).Where(MustCompile(exp))
+		if err != nil || !strings.HasSuffix(sql.Where, " OR c IS NULL)") {
+			t.Errorf("%s: should match NULL columns, got %v %v", exp, sql, err)
+		}
+	}
+
+	if _, err := Filter(MustCompile(`channel > 1`), rows); err == nil || !strings.HasPrefix(err.Error(), "row 0: ") {
+		t.Errorf("should report the failed row, got %v", err)
+	}
+}
+
+func TestPartialEval(t *testing.T) {
+	known := Map{
+		"channel": "email",
+		"account": map[string]any{"plan": "pro", "tags": []string{"a", "b"}, "bh": &struct{}{}},
+	}
+	tcs := []struct {
+		exp, residual string
+	}{
+		{`channel in ["email", "fb"] and user.country == "VN"`, `user.country == "VN"`},
+		{`channel == "sms" and user.country == "VN"`, `false`},
+		{`user.country == "VN" or account.plan == "pro"`, `true`},
+		{`user.country == "VN" and account.plan == "pro"`, `user.country == "VN"`},
+		{`user.tag in account.tags and lower(account.plan) == user.plan`, `user.tag in ["a", "b"] and "pro" == user.plan`},
+		{`daysSince(user.created) > len(account.tags)`, `daysSince(user.created) > 2`},
+		{`not (channel == "email") or len(account.bh) == user.count`, `len(account.bh) == user.count`},
+		{`-user.score < -len(channel)`, `-user.score < -5`},
+		{`len(channel) between 1 and 9 and user.created between 2024-01-01 and 2024-01-01 + 1w`,
+			`user.created between 2024-01-01 and 2024-01-08`},
+	}
+	for _, tc := range tcs {
+		res, err := PartialEval(MustCompile(tc.exp), known)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.exp, err)
+			continue
+		}
+		if res.String() != tc.residual {
+			t.Errorf("%s: should be %s, got %s", tc.exp, tc.residual, res)
+		}
+	}
+
+	p := MustCompile(`channel == "email" and user.age > 18`)
+	res, err := PartialEval(p, known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := res.Eval(Map{"user": map[string]any{"age": 20}}); err != nil || !ok {
+		t.Errorf("residual should be true, got %v %v", ok, err)
+	}
+
+	if _, err := PartialEval(MustCompile(`channel > 1`), known); err == nil {
+		t.Errorf("should fail on type error of known values")
+	}
+
+	// residuals are translatable and keep the clock
+	res, err = PartialEval(MustCompile(`x in ["a", "c"] and y == z and x not in account.tags`), Map{"z": 1, "account": known["account"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, err := (&SQLTranslator{}).Where(res)
+	if err != nil || sql.Where != `x IN (?, ?) AND y = ? AND (NOT (x IN (?, ?)) OR x IS NULL)` || fmt.Sprint(sql.Args) != "[a c 1 a b]" {
+		t.Errorf("should translate the residual, got %v %v", sql, err)
+	}
+	clk := clock.NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	res, err = PartialEval(MustCompile(`channel == "email" and created > now() - 2h`).WithClock(clk), known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := res.Eval(Map{"created": time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC)}); err != nil || !ok {
+		t.Errorf("residual should read the fake clock, got %v %v", ok, err)
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tcs := []struct {
+		exp, simplified string
+	}{
+		{`not (a == 1 and b < 2)`, `a != 1 or b >= 2`},
+		{`not not a`, `a`},
+		{`not (a or not b)`, `not a and b`},
+		{`a and b and a and (b and c)`, `a and b and c`},
+		{`a or (b or a) or a`, `a or b`},
+		{`a and not a and b`, `false`},
+		{`x > 1 or not (x > 1)`, `true`},
+		{`a and (1 < 2) and (lower("X") == "x" or b)`, `a`},
+		{`(a or b) and (b or a)`, `a or b`},
+		{`not (x in [1, 2])`, `not x in [1, 2]`},
+		{`a and false or b`, `b`},
+	}
+	for _, tc := range tcs {
+		p, err := Simplify(MustCompile(tc.exp))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tc.exp, err)
+			continue
+		}
+		if p.String() != tc.simplified {
+			t.Errorf("%s: should be %s, got %s", tc.exp, tc.simplified, p)
+		}
+	}
+	p, err := Simplify(MustCompile(`not (x in ["a", "b"] or 1 > 2)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sql, err := (&SQLTranslator{}).Where(p); err != nil || sql.Where != `(NOT (x IN (?, ?)) OR x IS NULL)` {
+		t.Errorf("should translate the simplified program, got %v %v", sql, err)
+	}
+}
@@ -0,0 +1,170 @@
+package expression
+
+import (
+	"strconv"
+	"strings"
+)
+
+// precedence levels of nodes, see parser
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precComparison
+	precAdd
+	precNeg
+	precPrimary
+)
+
+func precedence(n Node) int {
+	switch n := n.(type) {
+	case *UnaryExpr:
+		if n.Op == OpNot {
+			return precNot
+		}
+		return precNeg
+	case *BinaryExpr:
+		switch n.Op {
+		case OpOr:
+			return precOr
+		case OpAnd:
+			return precAnd
+		case OpAdd, OpSub:
+			return precAdd
+		}
+		return precComparison
+	case *BetweenExpr:
+		return precComparison
+	}
+	return precPrimary
+}
+
+// Format prints the syntax tree n as canonical source text: keywords are
+// lower case, strings are double quoted and only the parentheses needed to
+// keep the structure of the tree are written
+func Format(n Node) string { return format(n) }
+
+// format prints n as source text, adding only the parentheses needed to
+// keep the structure of the tree
+func format(n Node) string {
+	var sb strings.Builder
+	writeNode(&sb, n)
+	return sb.String()
+}
+
+func writeNode(sb *strings.Builder, n Node) {
+	switch n := n.(type) {
+	case *Literal:
+		writeValue(sb, n.Value)
+	case *Ident:
+		sb.WriteString(n.Name)
+	case *ListExpr:
+		sb.WriteByte('[')
+		for i, e := range n.Elems {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writeNode(sb, e)
+		}
+		sb.WriteByte(']')
+	case *CallExpr:
+		sb.WriteString(n.Func)
+		sb.WriteByte('(')
+		for i, a := range n.Args {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writeNode(sb, a)
+		}
+		sb.WriteByte(')')
+	case *UnaryExpr:
+		if n.Op == OpNot {
+			sb.WriteString("not ")
+			writeOperand(sb, n.X, precedence(n) > precedence(n.X))
+			break
+		}
+		sb.WriteString("-")
+		// -(-x) is kept as is rather than printed as --x
+		lit, isLit := n.X.(*Literal)
+		un, isUnary := n.X.(*UnaryExpr)
+		writeOperand(sb, n.X, precedence(n) > precedence(n.X) ||
+			(isLit && isNegative(lit.Value)) || (isUnary && un.Op == OpNeg))
+	case *BinaryExpr:
+		prec := precedence(n)
+		// comparisons are not associative, and/or are grouped to the left
+		writeOperand(sb, n.X, prec > precedence(n.X) || (prec == precComparison && prec == precedence(n.X)))
+		sb.WriteByte(' ')
+		sb.WriteString(n.Op.String())
+		sb.WriteByte(' ')
+		writeOperand(sb, n.Y, prec >= precedence(n.Y))
+	case *BetweenExpr:
+		writeOperand(sb, n.X, precComparison >= precedence(n.X))
+		sb.WriteString(" between ")
+		writeOperand(sb, n.Low, precComparison >= precedence(n.Low))
+		sb.WriteString(" and ")
+		writeOperand(sb, n.High, precComparison >= precedence(n.High))
+	}
+}
+
+// isNegative tells whether v is a negative number or duration literal
+func isNegative(v Value) bool {
+	return (v.kind == KindNumber && v.n < 0) || (v.kind == KindDuration && v.i < 0)
+}
+
+func writeOperand(sb *strings.Builder, n Node, paren bool) {
+	if paren {
+		sb.WriteByte('(')
+	}
+	writeNode(sb, n)
+	if paren {
+		sb.WriteByte(')')
+	}
+}
+
+func writeValue(sb *strings.Builder, v Value) {
+	switch v.kind {
+	case KindString:
+		writeString(sb, v.s)
+	case KindList:
+		sb.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writeValue(sb, v.Index(i))
+		}
+		sb.WriteByte(']')
+	case KindNumber:
+		sb.WriteString(strconv.FormatFloat(v.n, 'g', -1, 64))
+	default:
+		sb.WriteString(v.String())
+	}
+}
+
+// writeString quotes s using only the escapes understood by the lexer
+func writeString(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				sb.WriteString(`\u00`)
+				sb.WriteByte("0123456789abcdef"[r>>4])
+				sb.WriteByte("0123456789abcdef"[r&0xf])
+				continue
+			}
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+}
@@ -0,0 +1,275 @@
+package expression
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// Func is a typed function callable from expressions, e.g: lower(user.name)
+type Func struct {
+	Name     string
+	Params   []Kind // kinds of the parameters, KindAny accepts every kind
+	Variadic bool   // the last parameter may be repeated
+	Optional int    // number of trailing parameters which may be omitted
+	Result   Kind   // kind of the returned value, KindAny when it varies
+
+	// Pure tells that the result only depends on the arguments, so calls
+	// with constant arguments may be evaluated ahead of time, see PartialEval
+	Pure bool
+
+	// Call is called with arguments of the declared kinds. It must be safe
+	// for concurrent use and must not retain args.
+	Call func(args []Value) (Value, error)
+
+	// CallNow is like Call for functions reading the current time, now is
+	// the same for every call of an evaluation and comes from the clock of
+	// the program, see Program.WithClock. Either Call or CallNow is set.
+	CallNow func(now time.Time, args []Value) (Value, error)
+}
+
+// funcMap holds registered functions by name
+var funcMap sync.Map
+
+// Register makes a function callable from expressions compiled afterward.
+// Names are case-sensitive and may be dotted, e.g: account.isVip
+func Register(f *Func) error {
+	if f == nil || (f.Call == nil && f.CallNow == nil) {
+		return errors.New("expression: function must not be nil")
+	}
+	if !isName(f.Name) {
+		return fmt.Errorf("expression: invalid function name %q", f.Name)
+	}
+	if f.Variadic && len(f.Params) == 0 {
+		return fmt.Errorf("expression: variadic function %s must have parameters", f.Name)
+	}
+	if f.Optional < 0 || f.Optional > len(f.Params) {
+		return fmt.Errorf("expression: function %s has %d optional parameters out of %d", f.Name, f.Optional, len(f.Params))
+	}
+	if _, loaded := funcMap.LoadOrStore(f.Name, f); loaded {
+		return fmt.Errorf("expression: function %s is already registered", f.Name)
+	}
+	return nil
+}
+
+// LookupFunc returns the registered function of the given name
+func LookupFunc(name string) (*Func, bool) {
+	f, ok := funcMap.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return f.(*Func), true
+}
+
+// param returns the kind of the i-th parameter
+func (f *Func) param(i int) Kind {
+	if i >= len(f.Params) {
+		return f.Params[len(f.Params)-1]
+	}
+	return f.Params[i]
+}
+
+// checkArity tells whether f accepts n arguments
+func (f *Func) checkArity(n int) error {
+	min := len(f.Params) - f.Optional
+	if f.Variadic && min == len(f.Params) {
+		min--
+	}
+	if n >= min && (f.Variadic || n <= len(f.Params)) {
+		return nil
+	}
+	want := fmt.Sprintf("%d", len(f.Params))
+	switch {
+	case f.Variadic:
+		want = fmt.Sprintf("at least %d", min)
+	case min < len(f.Params):
+		want = fmt.Sprintf("%d to %d", min, len(f.Params))
+	}
+	return &CallError{Func: f.Name, Err: fmt.Errorf("want %s arguments, got %d", want, n)}
+}
+
+// accepts tells whether a parameter of kind param accepts a value of kind k
+func accepts(param, k Kind) bool { return param == KindAny || k == KindAny || param == k }
+
+// maxArgs is the number of arguments held by pooled buffers, calls with
+// more arguments allocate
+const maxArgs = 8
+
+var argPool = sync.Pool{New: func() any { return new([maxArgs]Value) }}
+
+// call evaluates the arguments then calls the function
+func call(c *code, e *evaluator) (Value, error) {
+	var args []Value
+	if len(c.args) <= maxArgs {
+		buf := argPool.Get().(*[maxArgs]Value)
+		defer func() {
+			*buf = [maxArgs]Value{}
+			argPool.Put(buf)
+		}()
+		args = buf[:len(c.args)]
+	} else {
+		args = make([]Value, len(c.args))
+	}
+
+	for i, a := range c.args {
+		v, err := a.eval(e)
+		if err != nil {
+			return Null, err
+		}
+		args[i] = v
+	}
+	return invoke(c.fn, args, e)
+}
+
+// invoke checks the arguments then calls fn
+func invoke(fn *Func, args []Value, e *evaluator) (Value, error) {
+	for i, v := range args {
+		if !accepts(fn.param(i), v.kind) {
+			return Null, &TypeError{Op: fn.Name + "()", Kinds: []Kind{v.kind}}
+		}
+	}
+	var v Value
+	var err error
+	if fn.CallNow != nil {
+		v, err = fn.CallNow(e.now(), args)
+	} else {
+		v, err = fn.Call(args)
+	}
+	if err != nil {
+		var cerr *CallError
+		if errors.As(err, &cerr) {
+			return Null, err
+		}
+		return Null, &CallError{Func: fn.Name, Err: err}
+	}
+	if !accepts(fn.Result, v.kind) {
+		return Null, &CallError{Func: fn.Name, Err: fmt.Errorf("returned %s instead of %s", v.kind, fn.Result)}
+	}
+	return v, e.checkString(v)
+}
+
+func init() {
+	for _, f := range []*Func{
+		{
+			Name:   "lower",
+			Pure:   true,
+			Params: []Kind{KindString},
+			Result: KindString,
+			Call:   func(args []Value) (Value, error) { return String(strings.ToLower(args[0].s)), nil },
+		},
+		{
+			Name:   "upper",
+			Pure:   true,
+			Params: []Kind{KindString},
+			Result: KindString,
+			Call:   func(args []Value) (Value, error) { return String(strings.ToUpper(args[0].s)), nil },
+		},
+		{
+			// number of characters of a string or elements of a list
+			Name:   "len",
+			Pure:   true,
+			Params: []Kind{KindAny},
+			Result: KindNumber,
+			Call: func(args []Value) (Value, error) {
+				switch args[0].kind {
+				case KindString:
+					return Number(float64(utf8.RuneCountInString(args[0].s))), nil
+				case KindList:
+					return Number(float64(args[0].Len())), nil
+				case KindNull:
+					return Number(0), nil
+				}
+				return Null, &TypeError{Op: "len()", Kinds: []Kind{args[0].kind}}
+			},
+		},
+		{
+			// current time
+			Name:    "now",
+			Result:  KindTime,
+			CallNow: func(now time.Time, args []Value) (Value, error) { return Time(now), nil },
+		},
+		{
+			// number of whole days elapsed since ts, a time or a timestamp in
+			// seconds, milliseconds, microseconds or nanoseconds
+			Name:   "daysSince",
+			Params: []Kind{KindAny},
+			Result: KindNumber,
+			CallNow: func(now time.Time, args []Value) (Value, error) {
+				ts, ok := timestamp(args[0])
+				if !ok {
+					return Null, &TypeError{Op: "daysSince()", Kinds: []Kind{args[0].kind}}
+				}
+				elapsed := now.UnixNano() - ts
+				return Number(float64(elapsed / int64(24*time.Hour))), nil
+			},
+		},
+		{
+			// day of week of ts in timezone tz (default UTC), e.g:
+			// dayOfWeek(createdAt, "Asia/Ho_Chi_Minh") returns "Monday"
+			Name:     "dayOfWeek",
+			Pure:     true,
+			Params:   []Kind{KindAny, KindString},
+			Optional: 1,
+			Result:   KindString,
+			Call: func(args []Value) (Value, error) {
+				_, _, _, _, _, weekday, err := convertTimezone("dayOfWeek()", args)
+				if err != nil {
+					return Null, err
+				}
+				return String(weekday), nil
+			},
+		},
+		{
+			// hour of day (0-23) of ts in timezone tz (default UTC), e.g:
+			// hourOfDay(now(), "+07:00")
+			Name:     "hourOfDay",
+			Pure:     true,
+			Params:   []Kind{KindAny, KindString},
+			Optional: 1,
+			Result:   KindNumber,
+			Call: func(args []Value) (Value, error) {
+				_, _, _, hour, _, _, err := convertTimezone("hourOfDay()", args)
+				if err != nil {
+					return Null, err
+				}
+				return Number(float64(hour)), nil
+			},
+		},
+		{
+			// current UTC offset of an IANA timezone, which changes with
+			// daylight saving time, e.g: tzOffset("Asia/Ho_Chi_Minh") returns
+			// "+07:00"
+			Name:   "tzOffset",
+			Params: []Kind{KindString},
+			Result: KindString,
+			CallNow: func(now time.Time, args []Value) (Value, error) {
+				return String(clock.TimezoneToUTCAt(args[0].s, now)), nil
+			},
+		},
+	} {
+		if err := Register(f); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// convertTimezone converts the time or timestamp args[0] to the timezone
+// args[1], an offset (+07:00) or an IANA name (Asia/Ho_Chi_Minh), see
+// clock.ConvertTimezoneIn
+func convertTimezone(name string, args []Value) (year, mon, day, hour, min int, weekday string, err error) {
+	ts, ok := timestamp(args[0])
+	if !ok {
+		err = &TypeError{Op: name, Kinds: []Kind{args[0].kind}}
+		return
+	}
+	tz := ""
+	if len(args) == 2 {
+		tz = args[1].s
+	}
+	return clock.ConvertTimezoneIn(time.Unix(0, ts), tz)
+}
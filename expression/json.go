@@ -0,0 +1,317 @@
+package expression
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// jsonNode is the JSON form of a Node. The format is stable:
+//
+//	{"type": "literal", "value": null | true | 1.5 | "VN"}
+//	{"type": "literal", "kind": "time", "value": "2024-01-01T00:00:00Z"}
+//	{"type": "literal", "kind": "duration", "value": "1d12h"}
+//	{"type": "ident", "name": "user.country"}
+//	{"type": "list", "elems": [<node>, ...]}
+//	{"type": "call", "func": "lower", "args": [<node>, ...]}
+//	{"type": "unary", "op": "not" | "-", "x": <node>}
+//	{"type": "binary", "op": "and" | "==" | "startsWith" | "+" | ..., "x": <node>, "y": <node>}
+//	{"type": "between", "x": <node>, "low": <node>, "high": <node>}
+//
+// Offsets are not kept.
+type jsonNode struct {
+	Type  string          `json:"type"`
+	Kind  string          `json:"kind,omitempty"` // time or duration literals
+	Value json.RawMessage `json:"value,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Func  string          `json:"func,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	X     *jsonNode       `json:"x,omitempty"`
+	Y     *jsonNode       `json:"y,omitempty"`
+	Low   *jsonNode       `json:"low,omitempty"`
+	High  *jsonNode       `json:"high,omitempty"`
+	Elems []*jsonNode     `json:"elems,omitempty"`
+	Args  []*jsonNode     `json:"args,omitempty"`
+}
+
+// Marshal encodes the syntax tree n as JSON, see Unmarshal
+func Marshal(n Node) ([]byte, error) {
+	jn, err := toJSON(n)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jn)
+}
+
+// Unmarshal decodes a syntax tree encoded by Marshal. The tree is validated
+// and must fit in DefaultLimits.
+func Unmarshal(data []byte) (Node, error) {
+	var jn jsonNode
+	if err := json.Unmarshal(data, &jn); err != nil {
+		return nil, err
+	}
+	nodes := 0
+	n, err := fromJSON(&jn, &nodes)
+	if err != nil {
+		return nil, err
+	}
+	if DefaultLimits.MaxNodes > 0 && nodes > DefaultLimits.MaxNodes {
+		return nil, &NodeLimitError{Limit: DefaultLimits.MaxNodes}
+	}
+	if DefaultLimits.MaxDepth > 0 && depth(n) > DefaultLimits.MaxDepth {
+		return nil, &DepthLimitError{Limit: DefaultLimits.MaxDepth}
+	}
+	return n, nil
+}
+
+func toJSON(n Node) (*jsonNode, error) {
+	switch n := n.(type) {
+	case *Literal:
+		return literalToJSON(n.Value)
+	case *Ident:
+		return &jsonNode{Type: "ident", Name: n.Name}, nil
+	case *ListExpr:
+		elems, err := nodesToJSON(n.Elems)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "list", Elems: elems}, nil
+	case *CallExpr:
+		args, err := nodesToJSON(n.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "call", Func: n.Func, Args: args}, nil
+	case *UnaryExpr:
+		x, err := toJSON(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "unary", Op: n.Op.String(), X: x}, nil
+	case *BinaryExpr:
+		x, err := toJSON(n.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := toJSON(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "binary", Op: n.Op.String(), X: x, Y: y}, nil
+	case *BetweenExpr:
+		x, err := toJSON(n.X)
+		if err != nil {
+			return nil, err
+		}
+		low, err := toJSON(n.Low)
+		if err != nil {
+			return nil, err
+		}
+		high, err := toJSON(n.High)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonNode{Type: "between", X: x, Low: low, High: high}, nil
+	}
+	return nil, fmt.Errorf("expression: cannot marshal node %T", n)
+}
+
+func nodesToJSON(nodes []Node) ([]*jsonNode, error) {
+	out := make([]*jsonNode, len(nodes))
+	for i, n := range nodes {
+		jn, err := toJSON(n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = jn
+	}
+	return out, nil
+}
+
+// literalToJSON encodes a literal, constant lists are encoded as lists of
+// literals
+func literalToJSON(v Value) (*jsonNode, error) {
+	var raw any
+	var kind string
+	switch v.kind {
+	case KindNull:
+	case KindBool:
+		raw = v.b
+	case KindNumber:
+		if math.IsNaN(v.n) || math.IsInf(v.n, 0) {
+			return nil, fmt.Errorf("expression: cannot marshal number %v", v.n)
+		}
+		raw = v.n
+	case KindString:
+		raw = v.s
+	case KindList:
+		elems := make([]*jsonNode, v.Len())
+		for i := range elems {
+			e, err := literalToJSON(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = e
+		}
+		return &jsonNode{Type: "list", Elems: elems}, nil
+	case KindTime, KindDuration:
+		kind, raw = v.kind.String(), v.String()
+	default:
+		return nil, fmt.Errorf("expression: cannot marshal %s literal", v.kind)
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonNode{Type: "literal", Kind: kind, Value: b}, nil
+}
+
+// unaryOpByName maps unary operator names of the JSON format to operators
+var unaryOpByName = map[string]Op{"not": OpNot, "-": OpNeg}
+
+// opByName maps binary operator names of the JSON format to operators, - is
+// OpSub
+var opByName = func() map[string]Op {
+	m := map[string]Op{}
+	for op, name := range opNames {
+		if name != "" && Op(op) != OpNot && Op(op) != OpNeg {
+			m[name] = Op(op)
+		}
+	}
+	return m
+}()
+
+func fromJSON(jn *jsonNode, nodes *int) (Node, error) {
+	if jn == nil {
+		return nil, fmt.Errorf("expression: missing node")
+	}
+	*nodes++
+	if DefaultLimits.MaxNodes > 0 && *nodes > DefaultLimits.MaxNodes {
+		return nil, &NodeLimitError{Limit: DefaultLimits.MaxNodes}
+	}
+
+	switch jn.Type {
+	case "literal":
+		var raw any
+		if len(jn.Value) > 0 {
+			if err := json.Unmarshal(jn.Value, &raw); err != nil {
+				return nil, err
+			}
+		}
+		if jn.Kind != "" {
+			return timeLiteralFromJSON(jn.Kind, raw)
+		}
+		switch v := raw.(type) {
+		case nil:
+			return &Literal{Value: Null}, nil
+		case bool:
+			return &Literal{Value: Bool(v)}, nil
+		case float64:
+			return &Literal{Value: Number(v)}, nil
+		case string:
+			if DefaultLimits.MaxStringLen > 0 && len(v) > DefaultLimits.MaxStringLen {
+				return nil, &StringLimitError{Limit: DefaultLimits.MaxStringLen, Len: len(v)}
+			}
+			return &Literal{Value: String(v)}, nil
+		}
+		return nil, fmt.Errorf("expression: invalid literal %s", jn.Value)
+	case "ident":
+		if !isName(jn.Name) {
+			return nil, fmt.Errorf("expression: invalid identifier %q", jn.Name)
+		}
+		return &Ident{Name: jn.Name}, nil
+	case "list":
+		elems, err := nodesFromJSON(jn.Elems, nodes)
+		if err != nil {
+			return nil, err
+		}
+		return &ListExpr{Elems: elems}, nil
+	case "call":
+		if !isName(jn.Func) {
+			return nil, fmt.Errorf("expression: invalid function name %q", jn.Func)
+		}
+		args, err := nodesFromJSON(jn.Args, nodes)
+		if err != nil {
+			return nil, err
+		}
+		return &CallExpr{Func: jn.Func, Args: args}, nil
+	case "unary":
+		op, ok := unaryOpByName[jn.Op]
+		if !ok {
+			return nil, fmt.Errorf("expression: invalid unary operator %q", jn.Op)
+		}
+		x, err := fromJSON(jn.X, nodes)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: op, X: x}, nil
+	case "binary":
+		op := opByName[jn.Op]
+		if op != OpAnd && op != OpOr && op != OpAdd && op != OpSub && !isComparison(op) {
+			return nil, fmt.Errorf("expression: invalid binary operator %q", jn.Op)
+		}
+		x, err := fromJSON(jn.X, nodes)
+		if err != nil {
+			return nil, err
+		}
+		y, err := fromJSON(jn.Y, nodes)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: op, X: x, Y: y}, nil
+	case "between":
+		x, err := fromJSON(jn.X, nodes)
+		if err != nil {
+			return nil, err
+		}
+		low, err := fromJSON(jn.Low, nodes)
+		if err != nil {
+			return nil, err
+		}
+		high, err := fromJSON(jn.High, nodes)
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{X: x, Low: low, High: high}, nil
+	}
+	return nil, fmt.Errorf("expression: invalid node type %q", jn.Type)
+}
+
+// timeLiteralFromJSON decodes a time or duration literal, written in the
+// syntax of the lexer
+func timeLiteralFromJSON(kind string, raw any) (Node, error) {
+	s, ok := raw.(string)
+	if !ok || (kind != "time" && kind != "duration") {
+		return nil, fmt.Errorf("expression: invalid %s literal %v", kind, raw)
+	}
+	n, err := Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("expression: invalid %s literal %q: %w", kind, s, err)
+	}
+	lit, ok := n.(*Literal)
+	if !ok || lit.Value.kind.String() != kind {
+		return nil, fmt.Errorf("expression: invalid %s literal %q", kind, s)
+	}
+	lit.Off = 0
+	return lit, nil
+}
+
+func nodesFromJSON(jns []*jsonNode, nodes *int) ([]Node, error) {
+	out := make([]Node, len(jns))
+	for i, jn := range jns {
+		n, err := fromJSON(jn, nodes)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+// isName tells whether s is a valid identifier or function name, keywords
+// are not names
+func isName(s string) bool {
+	l := lexer{src: s}
+	tok, err := l.next()
+	return err == nil && tok.kind == tokIdent && tok.text == s
+}
@@ -0,0 +1,373 @@
+package expression
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokLParen   // (
+	tokRParen   // )
+	tokLBracket // [
+	tokRBracket // ]
+	tokComma    // ,
+	tokMinus    // -
+	tokPlus     // +
+	tokOp       // operators and operator keywords, see token.op
+	tokTrue
+	tokFalse
+	tokNull
+	tokDuration // e.g: 7d, 1h30m
+	tokTime     // e.g: 2024-01-01, 2024-01-01T10:00:00+07:00
+)
+
+type token struct {
+	kind tokenKind
+	op   Op     // for tokOp
+	text string // identifier name, unquoted string, number, duration or time source
+	val  int64  // nanoseconds of durations, unix nanoseconds of times
+	off  int
+}
+
+// keywords are case-insensitive
+var keywords = map[string]token{
+	"and":        {kind: tokOp, op: OpAnd},
+	"or":         {kind: tokOp, op: OpOr},
+	"not":        {kind: tokOp, op: OpNot},
+	"in":         {kind: tokOp, op: OpIn},
+	"contains":   {kind: tokOp, op: OpContains},
+	"startswith": {kind: tokOp, op: OpStartsWith},
+	"endswith":   {kind: tokOp, op: OpEndsWith},
+	"matches":    {kind: tokOp, op: OpMatches},
+	"between":    {kind: tokOp, op: OpBetween},
+	"true":       {kind: tokTrue},
+	"false":      {kind: tokFalse},
+	"null":       {kind: tokNull},
+}
+
+// lexer splits the source into tokens
+type lexer struct {
+	src string
+	off int
+}
+
+func (l *lexer) errorf(off int, msg string) *SyntaxError {
+	return &SyntaxError{Offset: off, Msg: msg}
+}
+
+// unexpected reports a character which cannot start any token
+func (l *lexer) unexpected(off int) *SyntaxError {
+	r, _ := utf8.DecodeRuneInString(l.src[off:])
+	found := strconv.QuoteRune(r)
+	return &SyntaxError{Offset: off, Msg: "unexpected character " + found, Found: found}
+}
+
+// next returns the next token, tokEOF at the end of the source
+func (l *lexer) next() (token, error) {
+	for l.off < len(l.src) {
+		r, size := utf8.DecodeRuneInString(l.src[l.off:])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.off += size
+	}
+	start := l.off
+	if l.off >= len(l.src) {
+		return token{kind: tokEOF, off: start}, nil
+	}
+
+	c := l.src[l.off]
+	switch {
+	case c == '"' || c == '\'':
+		return l.scanString()
+	case isDate(l.src[l.off:]):
+		return l.scanTime()
+	case isDigit(c) || (c == '.' && l.off+1 < len(l.src) && isDigit(l.src[l.off+1])):
+		return l.scanNumber()
+	case isIdentStart(c):
+		return l.scanIdent(), nil
+	}
+
+	l.off++
+	two := ""
+	if l.off < len(l.src) {
+		two = l.src[start : l.off+1]
+	}
+	switch two {
+	case "==":
+		l.off++
+		return token{kind: tokOp, op: OpEq, off: start}, nil
+	case "!=":
+		l.off++
+		return token{kind: tokOp, op: OpNe, off: start}, nil
+	case "<=":
+		l.off++
+		return token{kind: tokOp, op: OpLe, off: start}, nil
+	case ">=":
+		l.off++
+		return token{kind: tokOp, op: OpGe, off: start}, nil
+	case "&&":
+		l.off++
+		return token{kind: tokOp, op: OpAnd, off: start}, nil
+	case "||":
+		l.off++
+		return token{kind: tokOp, op: OpOr, off: start}, nil
+	}
+
+	switch c {
+	case '(':
+		return token{kind: tokLParen, off: start}, nil
+	case ')':
+		return token{kind: tokRParen, off: start}, nil
+	case '[':
+		return token{kind: tokLBracket, off: start}, nil
+	case ']':
+		return token{kind: tokRBracket, off: start}, nil
+	case ',':
+		return token{kind: tokComma, off: start}, nil
+	case '-':
+		return token{kind: tokMinus, off: start}, nil
+	case '+':
+		return token{kind: tokPlus, off: start}, nil
+	case '<':
+		return token{kind: tokOp, op: OpLt, off: start}, nil
+	case '>':
+		return token{kind: tokOp, op: OpGt, off: start}, nil
+	case '!':
+		return token{kind: tokOp, op: OpNot, off: start}, nil
+	}
+	return token{}, l.unexpected(start)
+}
+
+// scanIdent scans an identifier or keyword. Identifiers may be dotted paths,
+// e.g: user.country
+func (l *lexer) scanIdent() token {
+	start := l.off
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		if isIdentPart(c) {
+			l.off++
+			continue
+		}
+		if c == '.' && l.off+1 < len(l.src) && isIdentStart(l.src[l.off+1]) {
+			l.off++
+			continue
+		}
+		break
+	}
+	name := l.src[start:l.off]
+	if kw, ok := keywords[strings.ToLower(name)]; ok {
+		kw.off = start
+		return kw
+	}
+	return token{kind: tokIdent, text: name, off: start}
+}
+
+func (l *lexer) scanNumber() (token, error) {
+	start := l.off
+	for l.off < len(l.src) && isDigit(l.src[l.off]) {
+		l.off++
+	}
+	if l.off < len(l.src) && l.src[l.off] == '.' {
+		l.off++
+		for l.off < len(l.src) && isDigit(l.src[l.off]) {
+			l.off++
+		}
+	}
+	if l.off < len(l.src) && (l.src[l.off] == 'e' || l.src[l.off] == 'E') {
+		l.off++
+		if l.off < len(l.src) && (l.src[l.off] == '+' || l.src[l.off] == '-') {
+			l.off++
+		}
+		digits := l.off
+		for l.off < len(l.src) && isDigit(l.src[l.off]) {
+			l.off++
+		}
+		if digits == l.off {
+			return token{}, l.errorf(start, "invalid number "+l.src[start:l.off])
+		}
+	}
+	if l.off < len(l.src) && isIdentStart(l.src[l.off]) {
+		l.off = start
+		return l.scanDuration()
+	}
+	return token{kind: tokNumber, text: l.src[start:l.off], off: start}, nil
+}
+
+// scanDuration scans a duration literal: a sequence of decimal numbers, each
+// with a unit among ns, us, ms, s, m, h, d (24h) and w (7d), e.g: 1d2h, 1.5h
+func (l *lexer) scanDuration() (token, error) {
+	start := l.off
+	var total int64
+	overflow := false
+	for l.off < len(l.src) && (isDigit(l.src[l.off]) || l.src[l.off] == '.') {
+		numStart := l.off
+		for l.off < len(l.src) && (isDigit(l.src[l.off]) || l.src[l.off] == '.') {
+			l.off++
+		}
+		n, err := strconv.ParseFloat(l.src[numStart:l.off], 64)
+		if err != nil {
+			return token{}, l.errorf(numStart, "invalid duration "+l.src[numStart:l.off])
+		}
+		unitStart := l.off
+		for l.off < len(l.src) && isIdentStart(l.src[l.off]) {
+			l.off++
+		}
+		unit, ok := durationUnitMap[l.src[unitStart:l.off]]
+		if !ok {
+			return token{}, l.errorf(start, "invalid number "+l.src[start:l.off])
+		}
+		v, ok := durationOf(l.src[numStart:unitStart], n, unit)
+		if !ok || total > math.MaxInt64-v {
+			overflow = true
+		}
+		total += v
+	}
+	if l.off < len(l.src) && isIdentPart(l.src[l.off]) {
+		return token{}, l.errorf(start, "invalid duration "+l.src[start:l.off+1])
+	}
+	if overflow {
+		return token{}, l.errorf(start, "duration out of range "+l.src[start:l.off])
+	}
+	return token{kind: tokDuration, text: l.src[start:l.off], val: total, off: start}, nil
+}
+
+// durationOf returns n units in nanoseconds, ok is false when it doesn't fit
+// in an int64. Integers are multiplied exactly, fractions in float64.
+func durationOf(text string, n float64, unit time.Duration) (int64, bool) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		if i > math.MaxInt64/int64(unit) {
+			return 0, false
+		}
+		return i * int64(unit), true
+	}
+	// float64(math.MaxInt64) rounds up to 1<<63
+	v := n * float64(unit)
+	if v >= 1<<63 {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+var durationUnitMap = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+// timeLayouts are the accepted layouts of time literals, times without
+// offset are in UTC
+var timeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999Z07:00",
+}
+
+// isDate tells whether s starts with a date, e.g: 2024-01-31
+func isDate(s string) bool {
+	if len(s) < 10 || s[4] != '-' || s[7] != '-' {
+		return false
+	}
+	for _, i := range []int{0, 1, 2, 3, 5, 6, 8, 9} {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return len(s) == 10 || !isIdentPart(s[10]) || s[10] == 'T'
+}
+
+// scanTime scans an ISO 8601 date or datetime literal
+func (l *lexer) scanTime() (token, error) {
+	start := l.off
+	l.off += 10
+	if l.off < len(l.src) && l.src[l.off] == 'T' {
+		for l.off < len(l.src) {
+			c := l.src[l.off]
+			if !isDigit(c) && !strings.ContainsRune("T:.Z+-", rune(c)) {
+				break
+			}
+			l.off++
+		}
+	}
+	text := l.src[start:l.off]
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return token{kind: tokTime, text: text, val: t.UnixNano(), off: start}, nil
+		}
+	}
+	return token{}, l.errorf(start, "invalid time "+text)
+}
+
+// scanString scans a single or double quoted string, supported escapes are
+// \" \' \\ \n \r \t and \uXXXX
+func (l *lexer) scanString() (token, error) {
+	start := l.off
+	quote := l.src[l.off]
+	l.off++
+	var sb strings.Builder
+	for l.off < len(l.src) {
+		c := l.src[l.off]
+		if c == '\\' && l.off+1 >= len(l.src) {
+			break // the escape is cut by the end of the source
+		}
+		switch {
+		case c == quote:
+			l.off++
+			return token{kind: tokString, text: sb.String(), off: start}, nil
+		case c == '\\':
+			esc := l.src[l.off+1]
+			l.off += 2
+			switch esc {
+			case '"', '\'', '\\':
+				sb.WriteByte(esc)
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				if l.off+4 > len(l.src) {
+					return token{}, l.errorf(l.off-2, "invalid escape")
+				}
+				code, err := strconv.ParseUint(l.src[l.off:l.off+4], 16, 32)
+				if err != nil {
+					return token{}, l.errorf(l.off-2, "invalid escape")
+				}
+				sb.WriteRune(rune(code))
+				l.off += 4
+			default:
+				return token{}, l.errorf(l.off-2, "invalid escape \\"+string(esc))
+			}
+		default:
+			sb.WriteByte(c)
+			l.off++
+		}
+	}
+	serr := l.errorf(start, "unterminated string")
+	serr.Expected = string(quote)
+	return token{}, serr
+}
+
+func isDigit(c byte) bool      { return '0' <= c && c <= '9' }
+func isIdentStart(c byte) bool { return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') }
+func isIdentPart(c byte) bool  { return isIdentStart(c) || isDigit(c) }
@@ -0,0 +1,108 @@
+package expression
+
+import (
+	"context"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// Limits bounds the resources used to compile and evaluate an expression,
+// protecting the service from rules authored by untrusted users. Zero fields
+// mean no limit.
+type Limits struct {
+	MaxDepth     int // nesting depth of the syntax tree
+	MaxNodes     int // number of nodes of the syntax tree
+	MaxStringLen int // length in bytes of string literals, variables and function results
+	MaxSteps     int // evaluation steps, each node evaluated or list element scanned costs 1
+}
+
+// DefaultLimits are used by Compile, Eval and ParseAndEval
+var DefaultLimits = Limits{
+	MaxDepth:     64,
+	MaxNodes:     1000,
+	MaxStringLen: 64 << 10,
+	MaxSteps:     100000,
+}
+
+// checkCtxEvery is the number of steps between context cancellation checks
+const checkCtxEvery = 64
+
+// evaluator holds the state of an evaluation
+type evaluator struct {
+	ctx    context.Context // nil when the evaluation cannot be canceled
+	env    Resolver
+	limits Limits
+	steps  int
+	clock  clock.Clock // default to clock.Real
+	at     time.Time   // current time of the evaluation, read once
+}
+
+// now returns the current time of the evaluation
+func (e *evaluator) now() time.Time {
+	if e.at.IsZero() {
+		e.at = clock.OrReal(e.clock).Now()
+	}
+	return e.at
+}
+
+// step charges n steps to the budget of the evaluation
+func (e *evaluator) step(n int) error {
+	prev := e.steps
+	e.steps += n
+	if e.limits.MaxSteps > 0 && e.steps > e.limits.MaxSteps {
+		return &StepLimitError{Limit: e.limits.MaxSteps}
+	}
+	if e.ctx != nil && (prev == 0 || prev/checkCtxEvery != e.steps/checkCtxEvery) {
+		if err := e.ctx.Err(); err != nil {
+			return &CanceledError{Err: err}
+		}
+	}
+	return nil
+}
+
+func (e *evaluator) checkString(v Value) error {
+	if v.kind == KindString && e.limits.MaxStringLen > 0 && len(v.s) > e.limits.MaxStringLen {
+		return &StringLimitError{Limit: e.limits.MaxStringLen, Len: len(v.s)}
+	}
+	return nil
+}
+
+func (e *evaluator) resolve(name string) (Value, error) {
+	v, err := resolve(name, e.env)
+	if err != nil {
+		return Null, err
+	}
+	return v, e.checkString(v)
+}
+
+// depth returns the depth of the syntax tree n
+func depth(n Node) int {
+	d := 0
+	switch n := n.(type) {
+	case *ListExpr:
+		for _, e := range n.Elems {
+			d = max(d, depth(e))
+		}
+	case *CallExpr:
+		for _, a := range n.Args {
+			d = max(d, depth(a))
+		}
+	case *UnaryExpr:
+		d = depth(n.X)
+	case *BinaryExpr:
+		d = max(operandDepth(n.Op, n.X), operandDepth(n.Op, n.Y))
+	case *BetweenExpr:
+		d = max(depth(n.X), depth(n.Low), depth(n.High))
+	}
+	return d + 1
+}
+
+// operandDepth returns the depth of x as an operand of op, a chain of the
+// same operator counts as a single level, e.g: a or b or c
+func operandDepth(op Op, x Node) int {
+	if b, ok := x.(*BinaryExpr); ok && b.Op == op {
+		return depth(x) - 1
+	}
+	return depth(x)
+}
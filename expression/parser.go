@@ -0,0 +1,450 @@
+package expression
+
+import (
+	"strconv"
+	"time"
+)
+
+// parser builds the syntax tree of an expression using recursive descent.
+// Operators from the lowest to the highest precedence:
+//
+//	or ||
+//	and &&
+//	not !
+//	== != < <= > >= in contains startsWith endsWith matches between (non associative)
+//	+ -
+//	- (unary)
+type parser struct {
+	lex    lexer
+	tok    token // current token
+	limits Limits
+	nodes  int // number of nodes created
+	nested int // current recursion depth
+}
+
+// Parse parses src into a syntax tree within DefaultLimits
+func Parse(src string) (Node, error) { return ParseWithLimits(src, DefaultLimits) }
+
+// ParseWithLimits parses src into a syntax tree, failing with
+// *DepthLimitError, *NodeLimitError or *StringLimitError when the tree
+// exceeds limits
+func ParseWithLimits(src string, limits Limits) (Node, error) {
+	p := &parser{lex: lexer{src: src}, limits: limits}
+	n, err := p.parse()
+	if err != nil {
+		if serr, ok := err.(*SyntaxError); ok {
+			serr.Line, serr.Column = position(src, serr.Offset)
+		}
+		return nil, err
+	}
+	if limits.MaxDepth > 0 && depth(n) > limits.MaxDepth {
+		return nil, &DepthLimitError{Limit: limits.MaxDepth}
+	}
+	return n, nil
+}
+
+// enter is called before recursing, it fails when the nesting is already
+// deeper than the tree is allowed to be
+func (p *parser) enter() error {
+	p.nested++
+	if p.limits.MaxDepth > 0 && p.nested > p.limits.MaxDepth {
+		return &DepthLimitError{Limit: p.limits.MaxDepth}
+	}
+	return nil
+}
+
+func (p *parser) leave() { p.nested-- }
+
+// count is called for every node created
+func (p *parser) count() error {
+	p.nodes++
+	if p.limits.MaxNodes > 0 && p.nodes > p.limits.MaxNodes {
+		return &NodeLimitError{Limit: p.limits.MaxNodes}
+	}
+	return nil
+}
+
+func (p *parser) parse() (Node, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected("end of expression")
+	}
+	return n, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) unexpected(expected string) *SyntaxError {
+	found := describe(p.tok)
+	return &SyntaxError{
+		Offset:   p.tok.off,
+		Msg:      "unexpected " + found + ", expected " + expected,
+		Expected: expected,
+		Found:    found,
+	}
+}
+
+func (p *parser) isOp(op Op) bool { return p.tok.kind == tokOp && p.tok.op == op }
+
+func (p *parser) parseOr() (Node, error) {
+	defer p.leave()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(OpOr) {
+		off := p.tok.off
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(); err != nil {
+			return nil, err
+		}
+		x = &BinaryExpr{Op: OpOr, X: x, Y: y, Off: off}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (Node, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(OpAnd) {
+		off := p.tok.off
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(); err != nil {
+			return nil, err
+		}
+		x = &BinaryExpr{Op: OpAnd, X: x, Y: y, Off: off}
+	}
+	return x, nil
+}
+
+func (p *parser) parseNot() (Node, error) {
+	if !p.isOp(OpNot) {
+		return p.parseComparison()
+	}
+	defer p.leave()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	off := p.tok.off
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.count(); err != nil {
+		return nil, err
+	}
+	return &UnaryExpr{Op: OpNot, X: x, Off: off}, nil
+}
+
+func isComparison(op Op) bool { return OpEq <= op && op <= OpMatches }
+
+func (p *parser) parseComparison() (Node, error) {
+	x, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	// a not in b is sugar for not (a in b), same for not between
+	negate := false
+	if p.isOp(OpNot) {
+		negate = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if !p.isOp(OpIn) && !p.isOp(OpBetween) {
+			return nil, p.unexpected("in or between after not")
+		}
+	}
+	if p.tok.kind != tokOp || (!isComparison(p.tok.op) && p.tok.op != OpBetween) {
+		return x, nil
+	}
+	op, off := p.tok.op, p.tok.off
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var n Node
+	if op == OpBetween {
+		if n, err = p.parseBetween(x, off); err != nil {
+			return nil, err
+		}
+	} else {
+		y, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(); err != nil {
+			return nil, err
+		}
+		n = &BinaryExpr{Op: op, X: x, Y: y, Off: off}
+	}
+	if p.tok.kind == tokOp && (isComparison(p.tok.op) || p.tok.op == OpBetween) {
+		return nil, &SyntaxError{
+			Offset: p.tok.off,
+			Msg:    "comparison operators cannot be chained, use parentheses",
+			Found:  describe(p.tok),
+		}
+	}
+	if negate {
+		n = &UnaryExpr{Op: OpNot, X: n, Off: off}
+	}
+	return n, nil
+}
+
+// parseBetween parses the bounds of x between low and high, the current
+// token is the one following between
+func (p *parser) parseBetween(x Node, off int) (Node, error) {
+	low, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp(OpAnd) {
+		return nil, p.unexpected("and")
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	high, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.count(); err != nil {
+		return nil, err
+	}
+	return &BetweenExpr{X: x, Low: low, High: high, Off: off}, nil
+}
+
+func (p *parser) parseAdditive() (Node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokPlus || p.tok.kind == tokMinus {
+		op, off := OpAdd, p.tok.off
+		if p.tok.kind == tokMinus {
+			op = OpSub
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(); err != nil {
+			return nil, err
+		}
+		x = &BinaryExpr{Op: op, X: x, Y: y, Off: off}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	if p.tok.kind != tokMinus {
+		return p.parsePrimary()
+	}
+	defer p.leave()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	off := p.tok.off
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	// fold negative number and duration literals
+	if lit, ok := x.(*Literal); ok && lit.Value.kind == KindNumber {
+		return &Literal{Value: Number(-lit.Value.n), Off: off}, nil
+	}
+	if lit, ok := x.(*Literal); ok && lit.Value.kind == KindDuration {
+		return &Literal{Value: Duration(-lit.Value.AsDuration()), Off: off}, nil
+	}
+	if err := p.count(); err != nil {
+		return nil, err
+	}
+	return &UnaryExpr{Op: OpNeg, X: x, Off: off}, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	tok := p.tok
+	if err := p.count(); err != nil {
+		return nil, err
+	}
+	switch tok.kind {
+	case tokTrue, tokFalse, tokNull, tokNumber, tokString, tokDuration, tokTime:
+		var v Value
+		switch tok.kind {
+		case tokTrue:
+			v = Bool(true)
+		case tokFalse:
+			v = Bool(false)
+		case tokNumber:
+			n, err := strconv.ParseFloat(tok.text, 64)
+			if err != nil {
+				return nil, &SyntaxError{Offset: tok.off, Msg: "invalid number " + tok.text, Found: describe(tok)}
+			}
+			v = Number(n)
+		case tokDuration:
+			v = Duration(time.Duration(tok.val))
+		case tokTime:
+			v = Value{kind: KindTime, i: tok.val}
+		case tokString:
+			if p.limits.MaxStringLen > 0 && len(tok.text) > p.limits.MaxStringLen {
+				return nil, &StringLimitError{Limit: p.limits.MaxStringLen, Len: len(tok.text)}
+			}
+			v = String(tok.text)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return &Literal{Value: v, Off: tok.off}, nil
+	case tokIdent:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokLParen {
+			return p.parseCall(tok)
+		}
+		return &Ident{Name: tok.text, Off: tok.off}, nil
+	case tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.unexpected(")")
+		}
+		return x, p.advance()
+	case tokLBracket:
+		return p.parseList()
+	}
+	return nil, p.unexpected("operand")
+}
+
+func (p *parser) parseList() (Node, error) {
+	list := &ListExpr{Off: p.tok.off}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for p.tok.kind != tokRBracket {
+		elem, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		list.Elems = append(list.Elems, elem)
+		if p.tok.kind == tokComma {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.tok.kind != tokRBracket {
+			return nil, p.unexpected(", or ]")
+		}
+	}
+	return list, p.advance()
+}
+
+// parseCall parses the arguments of function name, the current token is (
+func (p *parser) parseCall(name token) (Node, error) {
+	call := &CallExpr{Func: name.text, Off: name.off}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for p.tok.kind != tokRParen {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if p.tok.kind == tokComma {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.unexpected(", or )")
+		}
+	}
+	return call, p.advance()
+}
+
+// describe names a token in error messages
+func describe(tok token) string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokIdent:
+		return "identifier " + tok.text
+	case tokNumber:
+		return "number " + tok.text
+	case tokString:
+		return "string " + strconv.Quote(tok.text)
+	case tokLParen:
+		return "("
+	case tokRParen:
+		return ")"
+	case tokLBracket:
+		return "["
+	case tokRBracket:
+		return "]"
+	case tokComma:
+		return ","
+	case tokMinus:
+		return "-"
+	case tokPlus:
+		return "+"
+	case tokDuration:
+		return "duration " + tok.text
+	case tokTime:
+		return "time " + tok.text
+	case tokOp:
+		return tok.op.String()
+	case tokTrue:
+		return "true"
+	case tokFalse:
+		return "false"
+	case tokNull:
+		return "null"
+	}
+	return "token"
+}
@@ -0,0 +1,352 @@
+package expression
+
+import (
+	"sort"
+	"strings"
+)
+
+// PartialEval substitutes the variables known by env, folds constant sub
+// expressions and removes the branches they decide, returning a residual
+// program of the remaining variables, e.g: with channel = "email"
+//
+//	channel in ["email", "fb"] and user.country == "VN"
+//
+// becomes user.country == "VN". Operands dropped by short circuit are
+// assumed to evaluate without error. Calls are only folded for pure
+// functions.
+func PartialEval(p *Program, env Resolver) (*Program, error) {
+	root, err := partial(p.root, env)
+	if err != nil {
+		return nil, err
+	}
+	return residual(p, root)
+}
+
+// Simplify folds constants then rewrites the program to an equivalent
+// smaller or normalized form: negations are pushed down using de Morgan's
+// laws, double negations and duplicated operands of and/or are removed, and
+// chains holding both x and not x are decided. Operands are assumed to
+// evaluate to bool without error.
+func Simplify(p *Program) (*Program, error) {
+	root, err := partial(p.root, nil)
+	if err != nil {
+		return nil, err
+	}
+	root = simplify(root)
+	return residual(p, root)
+}
+
+// residual returns the program of root, derived from p
+func residual(p *Program, root Node) (*Program, error) {
+	out, err := newProgram(format(root), root, p.limits)
+	if err != nil {
+		return nil, err
+	}
+	out.clock = p.clock
+	return out, nil
+}
+
+// literal converts a Go value to a literal node, objects cannot be literals
+func literal(i any, off int) (*Literal, bool) {
+	v, ok := ValueOf(i)
+	if !ok || v.kind == KindObject {
+		return nil, false
+	}
+	return &Literal{Value: v, Off: off}, true
+}
+
+func isLiteral(n Node) bool {
+	_, ok := n.(*Literal)
+	return ok
+}
+
+func partial(n Node, env Resolver) (Node, error) {
+	e := &evaluator{}
+	switch n := n.(type) {
+	case *Ident:
+		if env == nil {
+			return n, nil
+		}
+		if i, ok := env.Resolve(n.Name); ok {
+			if lit, ok := literal(i, n.Off); ok {
+				return lit, nil
+			}
+		}
+		return n, nil
+	case *ListExpr:
+		out := &ListExpr{Off: n.Off}
+		konst := true
+		for _, elem := range n.Elems {
+			pe, err := partial(elem, env)
+			if err != nil {
+				return nil, err
+			}
+			out.Elems = append(out.Elems, pe)
+			konst = konst && isLiteral(pe)
+		}
+		if !konst {
+			return out, nil
+		}
+		vals := make([]Value, len(out.Elems))
+		for i, elem := range out.Elems {
+			vals[i] = elem.(*Literal).Value
+		}
+		return &Literal{Value: List(vals...), Off: n.Off}, nil
+	case *CallExpr:
+		out := &CallExpr{Func: n.Func, Off: n.Off}
+		konst := true
+		for _, a := range n.Args {
+			pa, err := partial(a, env)
+			if err != nil {
+				return nil, err
+			}
+			out.Args = append(out.Args, pa)
+			konst = konst && isLiteral(pa)
+		}
+		fn, ok := LookupFunc(n.Func)
+		if !ok || !fn.Pure || !konst {
+			return out, nil
+		}
+		if err := fn.checkArity(len(out.Args)); err != nil {
+			return nil, err
+		}
+		args := make([]Value, len(out.Args))
+		for i, a := range out.Args {
+			args[i] = a.(*Literal).Value
+		}
+		v, err := invoke(fn, args, e)
+		if err != nil {
+			return nil, err
+		}
+		return &Literal{Value: v, Off: n.Off}, nil
+	case *UnaryExpr:
+		x, err := partial(n.X, env)
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := x.(*Literal); ok {
+			v, err := unary(n.Op, lit.Value)
+			if err != nil {
+				return nil, err
+			}
+			return &Literal{Value: v, Off: n.Off}, nil
+		}
+		return &UnaryExpr{Op: n.Op, X: x, Off: n.Off}, nil
+	case *BinaryExpr:
+		x, err := partial(n.X, env)
+		if err != nil {
+			return nil, err
+		}
+		if n.Op == OpAnd || n.Op == OpOr {
+			return partialLogic(n, x, env)
+		}
+		y, err := partial(n.Y, env)
+		if err != nil {
+			return nil, err
+		}
+		xl, xok := x.(*Literal)
+		yl, yok := y.(*Literal)
+		if xok && yok {
+			v, err := binary(n.Op, xl.Value, yl.Value)
+			if err != nil {
+				return nil, err
+			}
+			return &Literal{Value: v, Off: n.Off}, nil
+		}
+		return &BinaryExpr{Op: n.Op, X: x, Y: y, Off: n.Off}, nil
+	case *BetweenExpr:
+		x, err := partial(n.X, env)
+		if err != nil {
+			return nil, err
+		}
+		low, err := partial(n.Low, env)
+		if err != nil {
+			return nil, err
+		}
+		high, err := partial(n.High, env)
+		if err != nil {
+			return nil, err
+		}
+		xl, xok := x.(*Literal)
+		ll, lok := low.(*Literal)
+		hl, hok := high.(*Literal)
+		if xok && lok && hok {
+			v, err := between(xl.Value, ll.Value, hl.Value)
+			if err != nil {
+				return nil, err
+			}
+			return &Literal{Value: v, Off: n.Off}, nil
+		}
+		return &BetweenExpr{X: x, Low: low, High: high, Off: n.Off}, nil
+	}
+	return n, nil
+}
+
+// partialLogic partially evaluates and/or whose left operand is x
+func partialLogic(n *BinaryExpr, x Node, env Resolver) (Node, error) {
+	or := n.Op == OpOr
+	if lit, ok := x.(*Literal); ok {
+		if lit.Value.kind != KindBool {
+			return nil, &TypeError{Op: n.Op.String(), Kinds: []Kind{lit.Value.kind}}
+		}
+		if lit.Value.b == or {
+			return lit, nil // short circuit
+		}
+		return partial(n.Y, env)
+	}
+	y, err := partial(n.Y, env)
+	if err != nil {
+		return nil, err
+	}
+	if lit, ok := y.(*Literal); ok {
+		if lit.Value.kind != KindBool {
+			return nil, &TypeError{Op: n.Op.String(), Kinds: []Kind{lit.Value.kind}}
+		}
+		if lit.Value.b == or {
+			return lit, nil
+		}
+		return x, nil
+	}
+	return &BinaryExpr{Op: n.Op, X: x, Y: y, Off: n.Off}, nil
+}
+
+// negatedOps maps comparisons to their negation
+var negatedOps = map[Op]Op{
+	OpEq: OpNe,
+	OpNe: OpEq,
+	OpLt: OpGe,
+	OpGe: OpLt,
+	OpGt: OpLe,
+	OpLe: OpGt,
+}
+
+func simplify(n Node) Node {
+	switch n := n.(type) {
+	case *ListExpr:
+		out := &ListExpr{Off: n.Off}
+		for _, e := range n.Elems {
+			out.Elems = append(out.Elems, simplify(e))
+		}
+		return out
+	case *CallExpr:
+		out := &CallExpr{Func: n.Func, Off: n.Off}
+		for _, a := range n.Args {
+			out.Args = append(out.Args, simplify(a))
+		}
+		return out
+	case *UnaryExpr:
+		if n.Op == OpNot {
+			return negate(simplify(n.X), n.Off)
+		}
+		return &UnaryExpr{Op: n.Op, X: simplify(n.X), Off: n.Off}
+	case *BinaryExpr:
+		if n.Op == OpAnd || n.Op == OpOr {
+			return simplifyLogic(n.Op, []Node{simplify(n.X), simplify(n.Y)}, n.Off)
+		}
+		return &BinaryExpr{Op: n.Op, X: simplify(n.X), Y: simplify(n.Y), Off: n.Off}
+	case *BetweenExpr:
+		return &BetweenExpr{X: simplify(n.X), Low: simplify(n.Low), High: simplify(n.High), Off: n.Off}
+	}
+	return n
+}
+
+// negate returns the simplified negation of the simplified node x
+func negate(x Node, off int) Node {
+	switch x := x.(type) {
+	case *Literal:
+		if x.Value.kind == KindBool {
+			return &Literal{Value: Bool(!x.Value.b), Off: x.Off}
+		}
+	case *UnaryExpr:
+		if x.Op == OpNot {
+			return x.X
+		}
+	case *BinaryExpr:
+		switch x.Op {
+		case OpAnd, OpOr:
+			// de Morgan
+			op := OpAnd
+			if x.Op == OpAnd {
+				op = OpOr
+			}
+			return simplifyLogic(op, []Node{negate(x.X, x.Off), negate(x.Y, x.Off)}, x.Off)
+		}
+		if op, ok := negatedOps[x.Op]; ok {
+			return &BinaryExpr{Op: op, X: x.X, Y: x.Y, Off: x.Off}
+		}
+	}
+	return &UnaryExpr{Op: OpNot, X: x, Off: off}
+}
+
+// logicKey identifies operands of and/or, ignoring the order of operands of
+// nested chains, e.g: a or b and b or a have the same key
+func logicKey(n Node) string {
+	b, ok := n.(*BinaryExpr)
+	if !ok || (b.Op != OpAnd && b.Op != OpOr) {
+		return format(n)
+	}
+	var keys []string
+	var collect func(n Node)
+	collect = func(n Node) {
+		if c, ok := n.(*BinaryExpr); ok && c.Op == b.Op {
+			collect(c.X)
+			collect(c.Y)
+			return
+		}
+		keys = append(keys, logicKey(n))
+	}
+	collect(b)
+	sort.Strings(keys)
+	return "(" + strings.Join(keys, ") "+b.Op.String()+" (") + ")"
+}
+
+// simplifyLogic builds the and/or chain of simplified operands, flattening
+// nested chains of the same operator and removing duplicates
+func simplifyLogic(op Op, operands []Node, off int) Node {
+	or := op == OpOr
+	var flat []Node
+	var flatten func(n Node)
+	flatten = func(n Node) {
+		if b, ok := n.(*BinaryExpr); ok && b.Op == op {
+			flatten(b.X)
+			flatten(b.Y)
+			return
+		}
+		flat = append(flat, n)
+	}
+	for _, o := range operands {
+		flatten(o)
+	}
+
+	seen := map[string]bool{}
+	var kept []Node
+	for _, o := range flat {
+		if lit, ok := o.(*Literal); ok && lit.Value.kind == KindBool {
+			if lit.Value.b == or {
+				return &Literal{Value: Bool(or), Off: off}
+			}
+			continue // neutral operand
+		}
+		key := logicKey(o)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, o)
+	}
+	for _, o := range kept {
+		// x and not x, x or not x
+		if seen[logicKey(negate(o, o.Offset()))] {
+			return &Literal{Value: Bool(or), Off: off}
+		}
+	}
+
+	if len(kept) == 0 {
+		return &Literal{Value: Bool(!or), Off: off}
+	}
+	out := kept[0]
+	for _, o := range kept[1:] {
+		out = &BinaryExpr{Op: op, X: out, Y: o, Off: off}
+	}
+	return out
+}
@@ -0,0 +1,407 @@
+package expression
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// Program is a compiled expression. It is immutable and safe to be evaluated
+// by multiple goroutines at the same time.
+type Program struct {
+	src    string
+	root   Node
+	code   *code
+	limits Limits
+	clock  clock.Clock
+}
+
+// code is a node of the compiled tree
+type code struct {
+	op    Op   // 0 for operands
+	kind  Kind // static kind of the result
+	konst bool // the result is known at compile time and stored in val
+	val   Value
+	name  string  // variable name
+	elems []*code // elements of a list literal which is not constant
+	fn    *Func   // called function
+	args  []*code // arguments of fn
+	x, y  *code
+	z     *code          // upper bound of between
+	re    *regexp.Regexp // precompiled pattern of matches operator
+	off   int
+}
+
+// Compile parses and type checks an expression. Operators on constants are
+// evaluated and regular expressions are compiled up front, so comparisons,
+// in, contains, matches and calls of functions building no string (e.g: len)
+// are evaluated without allocating. Calls of functions building strings
+// (e.g: lower(name)), even with constant arguments, and list literals holding
+// variables outside of in and contains operators allocate. Functions are
+// resolved when compiling, see Register.
+func Compile(src string) (*Program, error) { return CompileWithLimits(src, DefaultLimits) }
+
+// CompileWithLimits is like Compile, the returned program is also evaluated
+// within limits
+func CompileWithLimits(src string, limits Limits) (*Program, error) {
+	root, err := ParseWithLimits(src, limits)
+	if err != nil {
+		return nil, err
+	}
+	return newProgram(src, root, limits)
+}
+
+// newProgram compiles the syntax tree root of src
+func newProgram(src string, root Node, limits Limits) (*Program, error) {
+	c, err := compile(root)
+	if err != nil {
+		return nil, err
+	}
+	if c.kind != KindBool && c.kind != KindAny {
+		return nil, &TypeError{Op: "result", Kinds: []Kind{c.kind}}
+	}
+	return &Program{src: src, root: root, code: c, limits: limits}, nil
+}
+
+// MustCompile is like Compile but panics if the expression is invalid
+func MustCompile(src string) *Program {
+	p, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source of the program
+func (p *Program) String() string { return p.src }
+
+// Node returns the syntax tree of the program, it must not be modified
+func (p *Program) Node() Node { return p.root }
+
+// WithClock returns a copy of the program reading the current time of now(),
+// daysSince() and other functions with CallNow from clk, default to
+// clock.Real, e.g: p.WithClock(clock.NewFakeClock(t)) in tests
+func (p *Program) WithClock(clk clock.Clock) *Program {
+	cp := *p
+	cp.clock = clk
+	return &cp
+}
+
+// Eval evaluates the program against the variables of env
+func (p *Program) Eval(env Resolver) (bool, error) {
+	return p.eval(&evaluator{env: env, limits: p.limits, clock: p.clock})
+}
+
+// EvalContext is like Eval but stops with *CanceledError when ctx is done
+func (p *Program) EvalContext(ctx context.Context, env Resolver) (bool, error) {
+	return p.eval(&evaluator{ctx: ctx, env: env, limits: p.limits, clock: p.clock})
+}
+
+func (p *Program) eval(e *evaluator) (bool, error) {
+	v, err := p.code.eval(e)
+	if err != nil {
+		return false, err
+	}
+	if v.kind != KindBool {
+		return false, &TypeError{Op: "result", Kinds: []Kind{v.kind}}
+	}
+	return v.b, nil
+}
+
+func compile(n Node) (*code, error) {
+	switch n := n.(type) {
+	case *Literal:
+		return &code{kind: n.Value.kind, konst: true, val: n.Value, off: n.Off}, nil
+	case *Ident:
+		return &code{kind: KindAny, name: n.Name, off: n.Off}, nil
+	case *ListExpr:
+		c := &code{kind: KindList, konst: true, off: n.Off}
+		for _, e := range n.Elems {
+			ec, err := compile(e)
+			if err != nil {
+				return nil, err
+			}
+			c.elems = append(c.elems, ec)
+			c.konst = c.konst && ec.konst
+		}
+		if c.konst {
+			vals := make([]Value, len(c.elems))
+			for i, e := range c.elems {
+				vals[i] = e.val
+			}
+			c.val, c.elems = List(vals...), nil
+		}
+		return c, nil
+	case *CallExpr:
+		fn, ok := LookupFunc(n.Func)
+		if !ok {
+			return nil, &UndefinedFuncError{Name: n.Func}
+		}
+		if err := fn.checkArity(len(n.Args)); err != nil {
+			return nil, err
+		}
+		c := &code{kind: fn.Result, fn: fn, off: n.Off}
+		for i, a := range n.Args {
+			ac, err := compile(a)
+			if err != nil {
+				return nil, err
+			}
+			if !accepts(fn.param(i), ac.kind) {
+				return nil, &TypeError{Op: fn.Name + "()", Kinds: []Kind{ac.kind}}
+			}
+			c.args = append(c.args, ac)
+		}
+		return c, nil
+	case *UnaryExpr:
+		x, err := compile(n.X)
+		if err != nil {
+			return nil, err
+		}
+		kind, ok := unaryKind(n.Op, x.kind)
+		if !ok {
+			return nil, &TypeError{Op: n.Op.String(), Kinds: []Kind{x.kind}}
+		}
+		return fold(&code{op: n.Op, kind: kind, x: x, off: n.Off})
+	case *BetweenExpr:
+		x, err := compile(n.X)
+		if err != nil {
+			return nil, err
+		}
+		low, err := compile(n.Low)
+		if err != nil {
+			return nil, err
+		}
+		high, err := compile(n.High)
+		if err != nil {
+			return nil, err
+		}
+		if !orderedKinds(x.kind, low.kind) || !orderedKinds(x.kind, high.kind) {
+			return nil, &TypeError{Op: OpBetween.String(), Kinds: []Kind{x.kind, low.kind, high.kind}}
+		}
+		return fold(&code{op: OpBetween, kind: KindBool, x: x, y: low, z: high, off: n.Off})
+	case *BinaryExpr:
+		x, err := compile(n.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := compile(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		kind, ok := binaryKind(n.Op, x.kind, y.kind)
+		if !ok {
+			return nil, &TypeError{Op: n.Op.String(), Kinds: []Kind{x.kind, y.kind}}
+		}
+		c := &code{op: n.Op, kind: kind, x: x, y: y, off: n.Off}
+		if n.Op == OpMatches && y.konst {
+			if c.re, err = compileRegex(y.val.s); err != nil {
+				return nil, err
+			}
+		}
+		return fold(c)
+	}
+	return nil, &SyntaxError{Offset: n.Offset(), Msg: "unknown node"}
+}
+
+// fold evaluates c at compile time when its operands are constant
+func fold(c *code) (*code, error) {
+	if !c.x.konst || (c.y != nil && !c.y.konst) || (c.z != nil && !c.z.konst) {
+		return c, nil
+	}
+	v, err := c.eval(&evaluator{})
+	if err != nil {
+		return nil, err
+	}
+	return &code{kind: v.kind, konst: true, val: v, off: c.off}, nil
+}
+
+// unaryKind returns the kind of op applied to an operand of kind x, ok is
+// false when op doesn't support the operand
+func unaryKind(op Op, x Kind) (Kind, bool) {
+	switch op {
+	case OpNot:
+		return KindBool, x == KindBool || x == KindAny
+	case OpNeg:
+		if x == KindAny {
+			return KindAny, true
+		}
+		return x, x == KindNumber || x == KindDuration
+	}
+	return KindAny, false
+}
+
+// binaryKind returns the kind of op applied to operands of kinds x and y, ok
+// is false when op never supports the operands
+func binaryKind(op Op, x, y Kind) (Kind, bool) {
+	is := func(k, want Kind) bool { return k == want || k == KindAny }
+	switch op {
+	case OpAnd, OpOr:
+		return KindBool, is(x, KindBool) && is(y, KindBool)
+	case OpEq, OpNe:
+		return KindBool, x == y || x == KindAny || y == KindAny || x == KindNull || y == KindNull || orderedKinds(x, y)
+	case OpLt, OpLe, OpGt, OpGe:
+		return KindBool, orderedKinds(x, y)
+	case OpAdd, OpSub:
+		if x == KindAny || y == KindAny {
+			// the result depends on the operands, e.g: a time or a duration
+			_, ok := arithKind(op, x, y)
+			return KindAny, ok
+		}
+		return arithKind(op, x, y)
+	case OpIn:
+		return KindBool, is(y, KindList) || (is(y, KindString) && is(x, KindString))
+	case OpContains:
+		return KindBool, is(x, KindList) || (is(x, KindString) && is(y, KindString))
+	case OpStartsWith, OpEndsWith, OpMatches:
+		return KindBool, is(x, KindString) && is(y, KindString)
+	}
+	return KindAny, false
+}
+
+// operandKinds are the kinds tried for operands of unknown kind
+var operandKinds = []Kind{KindNumber, KindString, KindDuration, KindTime}
+
+// orderedKinds tells whether values of kinds x and y may be ordered
+func orderedKinds(x, y Kind) bool {
+	for _, a := range kindsOf(x) {
+		for _, b := range kindsOf(y) {
+			if _, ok := compare(Value{kind: a}, Value{kind: b}); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// arithKind returns the kind of x + y or x - y, trying every kind for
+// operands of unknown kind
+func arithKind(op Op, x, y Kind) (Kind, bool) {
+	for _, a := range kindsOf(x) {
+		for _, b := range kindsOf(y) {
+			if v, ok := arith(op, Value{kind: a}, Value{kind: b}); ok {
+				return v.kind, true
+			}
+		}
+	}
+	return KindAny, false
+}
+
+func kindsOf(k Kind) []Kind {
+	if k == KindAny {
+		return operandKinds
+	}
+	return []Kind{k}
+}
+
+func (c *code) eval(e *evaluator) (Value, error) {
+	if err := e.step(1); err != nil {
+		return Null, err
+	}
+	if c.konst {
+		return c.val, nil
+	}
+	switch c.op {
+	case 0:
+		if c.fn != nil {
+			return call(c, e)
+		}
+		if c.elems != nil {
+			vals := make([]Value, len(c.elems))
+			for i, elem := range c.elems {
+				v, err := elem.eval(e)
+				if err != nil {
+					return Null, err
+				}
+				vals[i] = v
+			}
+			return List(vals...), nil
+		}
+		return e.resolve(c.name)
+	case OpNot, OpNeg:
+		x, err := c.x.eval(e)
+		if err != nil {
+			return Null, err
+		}
+		return unary(c.op, x)
+	case OpAnd, OpOr:
+		x, err := c.x.eval(e)
+		if err != nil {
+			return Null, err
+		}
+		if x.kind != KindBool {
+			return Null, &TypeError{Op: c.op.String(), Kinds: []Kind{x.kind}}
+		}
+		// short circuit
+		if x.b == (c.op == OpOr) {
+			return x, nil
+		}
+		y, err := c.y.eval(e)
+		if err != nil {
+			return Null, err
+		}
+		if y.kind != KindBool {
+			return Null, &TypeError{Op: c.op.String(), Kinds: []Kind{x.kind, y.kind}}
+		}
+		return y, nil
+	case OpIn:
+		if c.y.elems != nil {
+			return inList(c.x, c.y.elems, e)
+		}
+	case OpContains:
+		if c.x.elems != nil {
+			return inList(c.y, c.x.elems, e)
+		}
+	}
+
+	x, err := c.x.eval(e)
+	if err != nil {
+		return Null, err
+	}
+	y, err := c.y.eval(e)
+	if err != nil {
+		return Null, err
+	}
+	switch {
+	case c.op == OpIn && y.kind == KindList:
+		err = e.step(y.Len())
+	case c.op == OpContains && x.kind == KindList:
+		err = e.step(x.Len())
+	}
+	if err != nil {
+		return Null, err
+	}
+	if c.op == OpBetween {
+		z, err := c.z.eval(e)
+		if err != nil {
+			return Null, err
+		}
+		return between(x, y, z)
+	}
+	if c.re != nil {
+		if x.kind != KindString {
+			return Null, &TypeError{Op: c.op.String(), Kinds: []Kind{x.kind, y.kind}}
+		}
+		return Bool(c.re.MatchString(x.s)), nil
+	}
+	return binary(c.op, x, y)
+}
+
+// inList tells whether elem equals one of the elements of a list literal
+// without building the list
+func inList(elem *code, list []*code, e *evaluator) (Value, error) {
+	x, err := elem.eval(e)
+	if err != nil {
+		return Null, err
+	}
+	for _, elem := range list {
+		v, err := elem.eval(e)
+		if err != nil {
+			return Null, err
+		}
+		if equal(x, v) {
+			return Bool(true), nil
+		}
+	}
+	return Bool(false), nil
+}
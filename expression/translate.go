@@ -0,0 +1,432 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Translator converts a compiled boolean expression to a predicate of a
+// backend, e.g: a SQL WHERE clause. Translators return *UnsupportedError for
+// constructs the backend cannot express.
+type Translator interface {
+	Translate(p *Program) (any, error)
+}
+
+// UnsupportedError is returned by translators for constructs they cannot
+// translate
+type UnsupportedError struct {
+	Translator string // e.g: sql
+	Expr       string // the sub expression, formatted
+	Reason     string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s: cannot translate %s: %s", e.Translator, e.Expr, e.Reason)
+}
+
+// SQL is a WHERE clause with its bind parameters
+type SQL struct {
+	Where string
+	Args  []any
+}
+
+// SQLTranslator translates expressions to SQL WHERE clauses. Literals are
+// passed as bind parameters, variables are columns.
+type SQLTranslator struct {
+	// Columns maps variables to columns, variables missing from the map are
+	// rejected. When nil, variables are used as column names as is.
+	Columns map[string]string
+
+	// Placeholder returns the bind parameter of the i-th (1-based) argument,
+	// default is ?. Use func(i int) string { return "$" + strconv.Itoa(i) }
+	// for PostgreSQL.
+	Placeholder func(i int) string
+}
+
+// Translate returns the *SQL of the program
+func (t *SQLTranslator) Translate(p *Program) (any, error) { return t.Where(p) }
+
+// Where returns the WHERE clause of the program, without the WHERE keyword
+func (t *SQLTranslator) Where(p *Program) (*SQL, error) {
+	s := &sqlBuilder{t: t, sql: &SQL{}}
+	where, err := s.build(p.root)
+	if err != nil {
+		return nil, err
+	}
+	s.sql.Where = where
+	return s.sql, nil
+}
+
+type sqlBuilder struct {
+	t   *SQLTranslator
+	sql *SQL
+}
+
+func (s *sqlBuilder) unsupported(n Node, reason string) error {
+	return &UnsupportedError{Translator: "sql", Expr: format(n), Reason: reason}
+}
+
+// bind adds a bind parameter and returns its placeholder
+func (s *sqlBuilder) bind(arg any) string {
+	s.sql.Args = append(s.sql.Args, arg)
+	if s.t.Placeholder == nil {
+		return "?"
+	}
+	return s.t.Placeholder(len(s.sql.Args))
+}
+
+// sqlFuncs maps functions to SQL functions
+var sqlFuncs = map[string]string{
+	"lower": "LOWER",
+	"upper": "UPPER",
+	"len":   "LENGTH",
+}
+
+// sqlOps maps comparison operators to SQL
+var sqlOps = map[Op]string{
+	OpEq: "=",
+	OpNe: "<>",
+	OpLt: "<",
+	OpLe: "<=",
+	OpGt: ">",
+	OpGe: ">=",
+}
+
+func (s *sqlBuilder) build(n Node) (string, error) {
+	switch n := n.(type) {
+	case *Literal:
+		switch n.Value.kind {
+		case KindNull:
+			return "NULL", nil
+		case KindBool:
+			if n.Value.b {
+				return "TRUE", nil
+			}
+			return "FALSE", nil
+		case KindNumber, KindString:
+			return s.bind(n.Value.Interface()), nil
+		case KindTime:
+			return s.bind(n.Value.AsTime()), nil
+		}
+		// durations have no portable SQL equivalent
+		return "", s.unsupported(n, n.Value.kind.String()+" literal")
+	case *Ident:
+		if s.t.Columns == nil {
+			return n.Name, nil
+		}
+		col, ok := s.t.Columns[n.Name]
+		if !ok {
+			return "", s.unsupported(n, "unknown column")
+		}
+		return col, nil
+	case *ListExpr:
+		return "", s.unsupported(n, "list outside of in operator")
+	case *CallExpr:
+		fn, ok := sqlFuncs[n.Func]
+		if !ok {
+			return "", s.unsupported(n, "function "+n.Func+" has no SQL equivalent")
+		}
+		args := make([]string, len(n.Args))
+		for i, a := range n.Args {
+			arg, err := s.build(a)
+			if err != nil {
+				return "", err
+			}
+			args[i] = arg
+		}
+		return fn + "(" + strings.Join(args, ", ") + ")", nil
+	case *UnaryExpr:
+		if n.Op == OpNot {
+			if b, ok := n.X.(*BinaryExpr); ok {
+				if out, ok, err := s.negated(b); ok || err != nil {
+					return out, err
+				}
+			}
+		}
+		x, err := s.build(n.X)
+		if err != nil {
+			return "", err
+		}
+		if n.Op == OpNot {
+			return "NOT (" + x + ")", nil
+		}
+		return "-(" + x + ")", nil
+	case *BinaryExpr:
+		return s.binary(n)
+	case *BetweenExpr:
+		x, err := s.value(n.X)
+		if err != nil {
+			return "", err
+		}
+		low, err := s.value(n.Low)
+		if err != nil {
+			return "", err
+		}
+		high, err := s.value(n.High)
+		if err != nil {
+			return "", err
+		}
+		return x + " BETWEEN " + low + " AND " + high, nil
+	}
+	return "", s.unsupported(n, "unknown node")
+}
+
+func (s *sqlBuilder) binary(n *BinaryExpr) (string, error) {
+	switch n.Op {
+	case OpAnd, OpOr:
+		x, err := s.operand(n.X, n.Op)
+		if err != nil {
+			return "", err
+		}
+		y, err := s.operand(n.Y, n.Op)
+		if err != nil {
+			return "", err
+		}
+		return x + " " + strings.ToUpper(n.Op.String()) + " " + y, nil
+	case OpEq, OpNe:
+		// comparing to NULL needs IS NULL
+		for _, pair := range [][2]Node{{n.X, n.Y}, {n.Y, n.X}} {
+			if lit, ok := pair[1].(*Literal); ok && lit.Value.kind == KindNull {
+				x, err := s.value(pair[0])
+				if err != nil {
+					return "", err
+				}
+				if n.Op == OpEq {
+					return x + " IS NULL", nil
+				}
+				return x + " IS NOT NULL", nil
+			}
+		}
+		if n.Op == OpNe {
+			return s.notEqual(n)
+		}
+		fallthrough
+	case OpLt, OpLe, OpGt, OpGe:
+		x, err := s.value(n.X)
+		if err != nil {
+			return "", err
+		}
+		y, err := s.value(n.Y)
+		if err != nil {
+			return "", err
+		}
+		return x + " " + sqlOps[n.Op] + " " + y, nil
+	case OpAdd, OpSub:
+		x, err := s.value(n.X)
+		if err != nil {
+			return "", err
+		}
+		y, err := s.value(n.Y)
+		if err != nil {
+			return "", err
+		}
+		// a - (b - c)
+		if b, ok := n.Y.(*BinaryExpr); ok && (b.Op == OpAdd || b.Op == OpSub) {
+			y = "(" + y + ")"
+		}
+		return x + " " + n.Op.String() + " " + y, nil
+	case OpIn:
+		if list, ok := listOf(n.Y); ok {
+			return s.in(n.X, list)
+		}
+		return s.like(n, n.Y, n.X, "%", "%")
+	case OpContains:
+		if list, ok := listOf(n.X); ok {
+			return s.in(n.Y, list)
+		}
+		return s.like(n, n.X, n.Y, "%", "%")
+	case OpStartsWith:
+		return s.like(n, n.X, n.Y, "", "%")
+	case OpEndsWith:
+		return s.like(n, n.X, n.Y, "%", "")
+	}
+	return "", s.unsupported(n, "operator "+n.Op.String()+" has no portable SQL equivalent")
+}
+
+// notEqual translates x != y, a NULL column is not equal to any value like
+// in Filter while <> on NULL is NULL in SQL. It is also the negation of
+// x == y.
+func (s *sqlBuilder) notEqual(n *BinaryExpr) (string, error) {
+	x, err := s.value(n.X)
+	if err != nil {
+		return "", err
+	}
+	y, err := s.value(n.Y)
+	if err != nil {
+		return "", err
+	}
+	_, xcol := n.X.(*Ident)
+	_, ycol := n.Y.(*Ident)
+	switch {
+	case xcol && ycol:
+		return "(" + x + " <> " + y + " OR " + x + " IS NULL AND " + y + " IS NOT NULL OR " + x + " IS NOT NULL AND " + y + " IS NULL)", nil
+	case xcol:
+		return "(" + x + " <> " + y + " OR " + x + " IS NULL)", nil
+	case ycol:
+		return "(" + x + " <> " + y + " OR " + y + " IS NULL)", nil
+	}
+	return x + " <> " + y, nil
+}
+
+// negated translates not applied to n when NOT would drop NULL columns which
+// Filter matches, ok is false for other operators
+func (s *sqlBuilder) negated(n *BinaryExpr) (out string, ok bool, err error) {
+	col, list := n.X, n.Y
+	switch n.Op {
+	case OpEq:
+		for _, operand := range []Node{n.X, n.Y} {
+			if lit, ok := operand.(*Literal); ok && lit.Value.kind == KindNull {
+				// IS NULL is never NULL
+				return "", false, nil
+			}
+		}
+		out, err = s.notEqual(n)
+		return out, true, err
+	case OpContains:
+		col, list = n.Y, n.X
+	case OpIn:
+	default:
+		return "", false, nil
+	}
+	elems, isList := listOf(list)
+	ident, isCol := col.(*Ident)
+	if !isList || !isCol || len(elems.Elems) == 0 {
+		return "", false, nil
+	}
+	in, err := s.in(col, elems)
+	if err != nil {
+		return "", true, err
+	}
+	c, err := s.build(ident)
+	if err != nil {
+		return "", true, err
+	}
+	return "(NOT (" + in + ") OR " + c + " IS NULL)", true, nil
+}
+
+// value builds an operand of a comparison, arithmetic, LIKE or IN,
+// parenthesizing boolean expressions, e.g: (a OR b) = TRUE
+func (s *sqlBuilder) value(n Node) (string, error) {
+	out, err := s.build(n)
+	if err != nil {
+		return "", err
+	}
+	switch n := n.(type) {
+	case *UnaryExpr:
+		if n.Op == OpNot {
+			return "(" + out + ")", nil
+		}
+	case *BinaryExpr:
+		if n.Op != OpAdd && n.Op != OpSub {
+			return "(" + out + ")", nil
+		}
+	case *BetweenExpr:
+		return "(" + out + ")", nil
+	}
+	return out, nil
+}
+
+// operand builds an operand of and/or, parenthesizing mixed and/or
+func (s *sqlBuilder) operand(n Node, op Op) (string, error) {
+	out, err := s.build(n)
+	if err != nil {
+		return "", err
+	}
+	if b, ok := n.(*BinaryExpr); ok && (b.Op == OpAnd || b.Op == OpOr) && b.Op != op {
+		return "(" + out + ")", nil
+	}
+	return out, nil
+}
+
+// listOf returns the elements of a list literal, either written like
+// ["a", b] or folded to a constant by PartialEval and Simplify
+func listOf(n Node) (*ListExpr, bool) {
+	switch n := n.(type) {
+	case *ListExpr:
+		return n, true
+	case *Literal:
+		if n.Value.kind != KindList {
+			return nil, false
+		}
+		list := &ListExpr{Off: n.Off}
+		for i := 0; i < n.Value.Len(); i++ {
+			list.Elems = append(list.Elems, &Literal{Value: n.Value.Index(i), Off: n.Off})
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+func (s *sqlBuilder) in(x Node, list *ListExpr) (string, error) {
+	if len(list.Elems) == 0 {
+		return "FALSE", nil
+	}
+	col, err := s.value(x)
+	if err != nil {
+		return "", err
+	}
+	elems := make([]string, len(list.Elems))
+	for i, e := range list.Elems {
+		if elems[i], err = s.value(e); err != nil {
+			return "", err
+		}
+	}
+	return col + " IN (" + strings.Join(elems, ", ") + ")", nil
+}
+
+// likeEscaper escapes wildcards of LIKE patterns with !, which unlike \ has
+// no special meaning in string literals of any SQL dialect
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// like translates substring operators, the pattern must be a string literal
+func (s *sqlBuilder) like(n Node, str, pattern Node, prefix, suffix string) (string, error) {
+	lit, ok := pattern.(*Literal)
+	if !ok || lit.Value.kind != KindString {
+		return "", s.unsupported(n, "the pattern must be a string literal")
+	}
+	col, err := s.value(str)
+	if err != nil {
+		return "", err
+	}
+	escaped := likeEscaper.Replace(lit.Value.s)
+	return col + " LIKE " + s.bind(prefix+escaped+suffix) + " ESCAPE '!'", nil
+}
+
+// FilterTranslator translates expressions to in-memory predicates over rows,
+// a missing key of a row is null like a NULL column
+type FilterTranslator struct{}
+
+// Translate returns the func(map[string]any) (bool, error) predicate of the
+// program
+func (FilterTranslator) Translate(p *Program) (any, error) {
+	return FilterTranslator{}.Predicate(p), nil
+}
+
+// Predicate returns a function telling whether a row matches the program
+func (FilterTranslator) Predicate(p *Program) func(row map[string]any) (bool, error) {
+	return func(row map[string]any) (bool, error) { return p.Eval(rowResolver(row)) }
+}
+
+// Filter returns the rows matching the program
+func Filter(p *Program, rows []map[string]any) ([]map[string]any, error) {
+	match := FilterTranslator{}.Predicate(p)
+	var out []map[string]any
+	for i, row := range rows {
+		ok, err := match(row)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		if ok {
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
+
+// rowResolver resolves variables in a row, missing keys are null
+type rowResolver map[string]any
+
+func (r rowResolver) Resolve(name string) (any, bool) {
+	v, _ := Map(r).Resolve(name)
+	return v, true
+}
@@ -0,0 +1,388 @@
+package expression
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the type of a Value
+type Kind uint8
+
+const (
+	KindNull Kind = iota
+	KindBool
+	KindNumber
+	KindString
+	KindList
+	KindObject   // opaque Go value only usable as function argument, e.g: a struct
+	KindTime     // instant, e.g: 2024-01-01, now()
+	KindDuration // e.g: 7d, 30m
+
+	// KindAny is the static kind of values only known at evaluation time,
+	// e.g: variables. Function parameters of KindAny accept every kind.
+	KindAny Kind = 255
+)
+
+var kindNames = [...]string{
+	KindNull:     "null",
+	KindBool:     "bool",
+	KindNumber:   "number",
+	KindString:   "string",
+	KindList:     "list",
+	KindObject:   "object",
+	KindTime:     "time",
+	KindDuration: "duration",
+}
+
+func (k Kind) String() string {
+	if k == KindAny {
+		return "any"
+	}
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Value is a value manipulated by expressions. Numbers are float64, lists may
+// hold values of mixed kinds.
+type Value struct {
+	kind Kind
+	b    bool
+	n    float64
+	i    int64 // unix nanoseconds of times, nanoseconds of durations
+	s    string
+	list []Value
+
+	// list given by the environment, e.g: []string, kept as is to avoid
+	// converting every element upfront, or the Go value of an object
+	raw any
+}
+
+// Null is the value of null literal
+var Null = Value{}
+
+// Bool creates a bool Value
+func Bool(b bool) Value { return Value{kind: KindBool, b: b} }
+
+// Number creates a number Value
+func Number(n float64) Value { return Value{kind: KindNumber, n: n} }
+
+// String creates a string Value
+func String(s string) Value { return Value{kind: KindString, s: s} }
+
+// List creates a list Value
+func List(elems ...Value) Value { return Value{kind: KindList, list: elems} }
+
+// Time creates a time Value, the location of t is not kept
+func Time(t time.Time) Value { return Value{kind: KindTime, i: t.UnixNano()} }
+
+// Duration creates a duration Value
+func Duration(d time.Duration) Value { return Value{kind: KindDuration, i: int64(d)} }
+
+// Object creates an object Value wrapping a Go value
+func Object(i any) Value { return Value{kind: KindObject, raw: i} }
+
+// Kind returns the kind of the value
+func (v Value) Kind() Kind { return v.kind }
+
+// AsBool returns the value of a bool Value, false for other kinds
+func (v Value) AsBool() bool { return v.b }
+
+// AsNumber returns the value of a number Value, 0 for other kinds
+func (v Value) AsNumber() float64 { return v.n }
+
+// AsString returns the value of a string Value, "" for other kinds
+func (v Value) AsString() string { return v.s }
+
+// AsTime returns the value of a time Value in UTC, zero time for other kinds
+func (v Value) AsTime() time.Time {
+	if v.kind != KindTime {
+		return time.Time{}
+	}
+	return time.Unix(0, v.i).UTC()
+}
+
+// AsDuration returns the value of a duration Value, 0 for other kinds
+func (v Value) AsDuration() time.Duration {
+	if v.kind != KindDuration {
+		return 0
+	}
+	return time.Duration(v.i)
+}
+
+// AsObject returns the Go value of an object Value, nil for other kinds
+func (v Value) AsObject() any {
+	if v.kind != KindObject {
+		return nil
+	}
+	return v.raw
+}
+
+// Len returns number of elements of a list Value, 0 for other kinds
+func (v Value) Len() int {
+	if v.kind != KindList {
+		return 0
+	}
+	if v.raw == nil {
+		return len(v.list)
+	}
+	switch l := v.raw.(type) {
+	case []any:
+		return len(l)
+	case []string:
+		return len(l)
+	case []int:
+		return len(l)
+	case []int64:
+		return len(l)
+	case []float64:
+		return len(l)
+	case []bool:
+		return len(l)
+	}
+	return reflect.ValueOf(v.raw).Len()
+}
+
+// Index returns the i-th element of a list Value
+func (v Value) Index(i int) Value {
+	if v.raw == nil {
+		return v.list[i]
+	}
+	switch l := v.raw.(type) {
+	case []any:
+		e, _ := ValueOf(l[i])
+		return e
+	case []string:
+		return String(l[i])
+	case []int:
+		return Number(float64(l[i]))
+	case []int64:
+		return Number(float64(l[i]))
+	case []float64:
+		return Number(l[i])
+	case []bool:
+		return Bool(l[i])
+	}
+	e, _ := ValueOf(reflect.ValueOf(v.raw).Index(i).Interface())
+	return e
+}
+
+// Interface converts the value back to a Go value: nil, bool, float64,
+// string, []any, time.Time, time.Duration or the Go value of an object
+func (v Value) Interface() any {
+	switch v.kind {
+	case KindTime:
+		return v.AsTime()
+	case KindDuration:
+		return v.AsDuration()
+	case KindObject:
+		return v.raw
+	case KindBool:
+		return v.b
+	case KindNumber:
+		return v.n
+	case KindString:
+		return v.s
+	case KindList:
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = v.Index(i).Interface()
+		}
+		return out
+	}
+	return nil
+}
+
+// String formats the value as an expression literal
+func (v Value) String() string {
+	switch v.kind {
+	case KindBool:
+		return strconv.FormatBool(v.b)
+	case KindNumber:
+		return strconv.FormatFloat(v.n, 'g', -1, 64)
+	case KindString:
+		return strconv.Quote(v.s)
+	case KindList:
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = v.Index(i).String()
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case KindObject:
+		return fmt.Sprintf("object(%T)", v.raw)
+	case KindTime:
+		return formatTime(v.i)
+	case KindDuration:
+		return formatDuration(v.i)
+	}
+	return "null"
+}
+
+// ValueOf converts a Go value given by the environment to a Value. Supported
+// types are nil, bool, integers, floats, string and slices or arrays of them.
+// time.Time and time.Duration become times and durations. Other structs,
+// maps and pointers to them become objects.
+func ValueOf(i any) (Value, bool) {
+	switch v := i.(type) {
+	case nil:
+		return Null, true
+	case time.Time:
+		return Time(v), true
+	case *time.Time:
+		if v == nil {
+			return Null, true
+		}
+		return Time(*v), true
+	case time.Duration:
+		return Duration(v), true
+	case Value:
+		return v, true
+	case bool:
+		return Bool(v), true
+	case string:
+		return String(v), true
+	case float64:
+		return Number(v), true
+	case float32:
+		return Number(float64(v)), true
+	case int:
+		return Number(float64(v)), true
+	case int8:
+		return Number(float64(v)), true
+	case int16:
+		return Number(float64(v)), true
+	case int32:
+		return Number(float64(v)), true
+	case int64:
+		return Number(float64(v)), true
+	case uint:
+		return Number(float64(v)), true
+	case uint8:
+		return Number(float64(v)), true
+	case uint16:
+		return Number(float64(v)), true
+	case uint32:
+		return Number(float64(v)), true
+	case uint64:
+		return Number(float64(v)), true
+	case []any, []string, []int, []int64, []float64, []bool:
+		return Value{kind: KindList, raw: v}, true
+	case []Value:
+		return List(v...), true
+	}
+
+	rv := reflect.ValueOf(i)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		return Value{kind: KindList, raw: i}, true
+	case reflect.Struct, reflect.Map:
+		return Object(i), true
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return Null, true
+		}
+		if k := rv.Elem().Kind(); k == reflect.Struct || k == reflect.Map {
+			return Object(i), true
+		}
+		return ValueOf(rv.Elem().Interface())
+	case reflect.String:
+		return String(rv.String()), true
+	case reflect.Bool:
+		return Bool(rv.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Number(float64(rv.Int())), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Number(float64(rv.Uint())), true
+	case reflect.Float32, reflect.Float64:
+		return Number(rv.Float()), true
+	}
+	return Null, false
+}
+
+// equal tells whether a and b are the same value, values of different kinds
+// are never equal
+func equal(a, b Value) bool {
+	if a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case KindNull:
+		return true
+	case KindBool:
+		return a.b == b.b
+	case KindNumber:
+		return a.n == b.n
+	case KindTime, KindDuration:
+		return a.i == b.i
+	case KindString:
+		return a.s == b.s
+	case KindList:
+		n := a.Len()
+		if n != b.Len() {
+			return false
+		}
+		for i := 0; i < n; i++ {
+			if !equal(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case KindObject:
+		t := reflect.TypeOf(a.raw)
+		if t == nil || t != reflect.TypeOf(b.raw) || !t.Comparable() {
+			return a.raw == nil && b.raw == nil
+		}
+		return a.raw == b.raw
+	}
+	return false
+}
+
+// formatTime formats unix nanoseconds as a date literal, or a datetime literal
+// in UTC when the time is not midnight UTC
+func formatTime(ns int64) string {
+	t := time.Unix(0, ns).UTC()
+	if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0 {
+		return t.Format("2006-01-02")
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// durationUnits are the units of duration literals, from the largest
+var durationUnits = []struct {
+	name string
+	d    time.Duration
+}{
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+	{"us", time.Microsecond},
+	{"ns", time.Nanosecond},
+}
+
+// formatDuration formats nanoseconds as a duration literal, e.g: 1d2h30m
+func formatDuration(ns int64) string {
+	if ns == 0 {
+		return "0s"
+	}
+	var sb strings.Builder
+	// the most negative duration cannot be negated, so work on unsigned
+	u := uint64(ns)
+	if ns < 0 {
+		sb.WriteByte('-')
+		u = -u
+	}
+	for _, unit := range durationUnits {
+		if n := u / uint64(unit.d); n > 0 {
+			sb.WriteString(strconv.FormatUint(n, 10))
+			sb.WriteString(unit.name)
+			u -= n * uint64(unit.d)
+		}
+	}
+	return sb.String()
+}
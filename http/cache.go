@@ -0,0 +1,336 @@
+package http
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	nethttp "net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// Cache stores responses of GET requests so they can be served again without
+// touching the network while fresh, or revalidated cheaply with
+// If-None-Match/If-Modified-Since once stale. See Config.Cache.
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, res *CachedResponse)
+	Delete(key string)
+}
+
+// CachedResponse is a response stored in a Cache
+type CachedResponse struct {
+	StatusCode int            `json:"status_code"`
+	Header     nethttp.Header `json:"header"`
+	Body       []byte         `json:"body"`
+
+	// values of request headers listed in the response Vary header, the entry
+	// is only reused for requests having the same values
+	Vary map[string]string `json:"vary,omitempty"`
+
+	// time the response was received or last revalidated
+	StoredAt time.Time `json:"stored_at"`
+}
+
+// cacheableCodes are status codes which are heuristically cacheable, see RFC
+// 9110 section 15.1
+var cacheableCodes = map[int]bool{200: true, 203: true, 204: true, 300: true,
+	301: true, 404: true, 405: true, 410: true, 414: true, 501: true}
+
+// parseCacheControl splits Cache-Control header value into directives, e.g:
+// "max-age=60, no-cache" => {"max-age": "60", "no-cache": ""}
+func parseCacheControl(h nethttp.Header) map[string]string {
+	out := map[string]string{}
+	for _, line := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			k, v, _ := strings.Cut(part, "=")
+			out[strings.ToLower(strings.TrimSpace(k))] = strings.Trim(strings.TrimSpace(v), `"`)
+		}
+	}
+	return out
+}
+
+// isStorable tells whether a response could be stored, see RFC 9111 section 3
+func isStorable(reqheader map[string]string, code int, header nethttp.Header) bool {
+	if !cacheableCodes[code] || header.Get("Vary") == "*" {
+		return false
+	}
+	if _, has := parseCacheControl(nethttp.Header{"Cache-Control": {reqheader["Cache-Control"]}})["no-store"]; has {
+		return false
+	}
+	cc := parseCacheControl(header)
+	if _, has := cc["no-store"]; has {
+		return false
+	}
+	if _, has := cc["max-age"]; has {
+		return true
+	}
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != "" ||
+		header.Get("Expires") != ""
+}
+
+// freshness returns how long the response stays fresh since it was
+// generated, see RFC 9111 section 4.2.1
+func freshness(header nethttp.Header) time.Duration {
+	cc := parseCacheControl(header)
+	if _, has := cc["no-cache"]; has {
+		return 0
+	}
+	if v, has := cc["max-age"]; has {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+
+	date, err := nethttp.ParseTime(header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		exp, err := nethttp.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return exp.Sub(date)
+	}
+
+	// heuristic freshness, 10% of the time since the last modification
+	if lm, err := nethttp.ParseTime(header.Get("Last-Modified")); err == nil && lm.Before(date) {
+		return date.Sub(lm) / 10
+	}
+	return 0
+}
+
+// isFresh tells whether the cached response could be served without
+// revalidation, see RFC 9111 section 4.2.3
+func (res *CachedResponse) isFresh(now time.Time) bool {
+	age := now.Sub(res.StoredAt)
+	if v, err := strconv.ParseInt(res.Header.Get("Age"), 10, 64); err == nil {
+		age += time.Duration(v) * time.Second
+	}
+	return age < freshness(res.Header)
+}
+
+// matchVary tells whether the request header has the same values as the
+// request that produced the cached response for all fields listed in Vary
+func (res *CachedResponse) matchVary(header map[string]string) bool {
+	for k, v := range res.Vary {
+		if headerValue(header, k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// headerValue looks up header case-insensitively
+func headerValue(header map[string]string, key string) string {
+	if v, ok := header[key]; ok {
+		return v
+	}
+	for k, v := range header {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// sendCached serves GET requests from config.Cache. Fresh responses are
+// returned as is, stale ones are revalidated using their validators.
+// Successful unsafe requests invalidate the stored response of the same url.
+func (me *Client) sendCached(key string, send func(header map[string]string) ([]byte, int, nethttp.Header), method string, header map[string]string, cache Cache) ([]byte, int, nethttp.Header) {
+	if method != "GET" {
+		out, code, respheader := send(header)
+		if method != "HEAD" && (Is2xx(code) || (299 < code && code < 400)) {
+			cache.Delete(key)
+		}
+		return out, code, respheader
+	}
+
+	cached, ok := cache.Get(key)
+	if ok && !cached.matchVary(header) {
+		cached, ok = nil, false
+	}
+	clk := clock.OrReal(me.Clock)
+	if ok && cached.isFresh(clk.Now()) {
+		// copy so callers modifying the body don't corrupt the cache
+		return append([]byte(nil), cached.Body...), cached.StatusCode, cached.Header.Clone()
+	}
+
+	if ok {
+		condheader := make(map[string]string, len(header)+2)
+		for k, v := range header {
+			condheader[k] = v
+		}
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			condheader["If-None-Match"] = etag
+		}
+		if lm := cached.Header.Get("Last-Modified"); lm != "" {
+			condheader["If-Modified-Since"] = lm
+		}
+		header = condheader
+	}
+
+	out, code, respheader := send(header)
+	if ok && code == 304 {
+		// refresh the stored header with the new metadata, see RFC 9111
+		// section 4.3.4
+		updated := *cached
+		updated.Header = cached.Header.Clone()
+		for k, v := range respheader {
+			updated.Header[k] = v
+		}
+		updated.StoredAt = clk.Now()
+		cache.Set(key, &updated)
+		return append([]byte(nil), updated.Body...), updated.StatusCode, updated.Header.Clone()
+	}
+
+	if !isStorable(header, code, respheader) {
+		return out, code, respheader
+	}
+
+	var vary map[string]string
+	for _, line := range respheader.Values("Vary") {
+		for _, k := range strings.Split(line, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				if vary == nil {
+					vary = map[string]string{}
+				}
+				vary[k] = headerValue(header, k)
+			}
+		}
+	}
+	cache.Set(key, &CachedResponse{
+		StatusCode: code,
+		Header:     respheader,
+		Body:       append([]byte(nil), out...),
+		Vary:       vary,
+		StoredAt:   clk.Now(),
+	})
+	return out, code, respheader
+}
+
+// memoryCache is an in-memory LRU Cache
+type memoryCache struct {
+	sync.Mutex
+	max   int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key string
+	res *CachedResponse
+}
+
+// NewMemoryCache creates an in-memory Cache which keeps at most maxEntries
+// responses, the least recently used ones are evicted first. A non positive
+// maxEntries means no limit.
+func NewMemoryCache(maxEntries int) Cache {
+	return &memoryCache{max: maxEntries, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *memoryCache) Get(key string) (*CachedResponse, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*memoryEntry).res, true
+}
+
+func (c *memoryCache) Set(key string, res *CachedResponse) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*memoryEntry).res = res
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&memoryEntry{key: key, res: res})
+	if c.max > 0 && c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+func (c *memoryCache) Delete(key string) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// diskCache is a Cache storing each response as a JSON file in a directory
+type diskCache struct {
+	dir string
+}
+
+// NewDiskCache creates a Cache which persists responses under dir, the
+// directory is created if not exists
+func NewDiskCache(dir string) (Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskCache{dir: dir}, nil
+}
+
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *diskCache) Get(key string) (*CachedResponse, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	res := &CachedResponse{}
+	if err := json.Unmarshal(b, res); err != nil {
+		return nil, false
+	}
+	return res, true
+}
+
+func (c *diskCache) Set(key string, res *CachedResponse) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+	// write to a temporary file then rename, so readers never see a partial
+	// entry
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (c *diskCache) Delete(key string) { os.Remove(c.path(key)) }
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultCompressMinSize is the body size from which request bodies are
+// compressed when Config.Compression is set
+const defaultCompressMinSize = 1024
+
+// Encoding is a content coding (RFC 9110 section 8.4.1) that can be used to
+// compress request bodies and decompress response bodies
+type Encoding struct {
+	// NewWriter wraps w with a compressor
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader wraps r with a decompressor
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// encodingMap maps coding name (e.g: gzip) to its Encoding. gzip and deflate
+// are built in, others such as br or zstd are not in the standard library and
+// must be registered by the application with RegisterEncoding.
+var encodingMap = &sync.Map{}
+
+// customEncoding is true once an encoding other than the built in ones is
+// registered, from then on response decompression is done by us instead of
+// the transport, since the transport only knows gzip
+var customEncoding atomic.Bool
+
+func init() {
+	encodingMap.Store("gzip", &Encoding{
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	})
+	encodingMap.Store("deflate", &Encoding{
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.DefaultCompression) },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+	})
+}
+
+// RegisterEncoding makes a content coding available to Config.Compression and
+// to response decompression, it should be called in init, e.g:
+//
+//	http.RegisterEncoding("zstd", &http.Encoding{
+//		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+//		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+//			d, err := zstd.NewReader(r)
+//			if err != nil {
+//				return nil, err
+//			}
+//			return d.IOReadCloser(), nil
+//		},
+//	})
+func RegisterEncoding(name string, enc *Encoding) {
+	encodingMap.Store(strings.ToLower(name), enc)
+	customEncoding.Store(true)
+}
+
+func getEncoding(name string) (*Encoding, bool) {
+	enc, ok := encodingMap.Load(strings.ToLower(strings.TrimSpace(name)))
+	if !ok {
+		return nil, false
+	}
+	return enc.(*Encoding), true
+}
+
+// acceptEncoding returns value for the Accept-Encoding header, listing all
+// registered codings which can decompress
+func acceptEncoding() string {
+	var names []string
+	encodingMap.Range(func(k, v any) bool {
+		if v.(*Encoding).NewReader != nil {
+			names = append(names, k.(string))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// compress encodes body using the named coding
+func compress(name string, body []byte) ([]byte, error) {
+	enc, ok := getEncoding(name)
+	if !ok || enc.NewWriter == nil {
+		return nil, fmt.Errorf("unsupported encoding %s", name)
+	}
+	var buf bytes.Buffer
+	w, err := enc.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress wraps r with decoders listed in Content-Encoding, in reverse
+// order of application. Closing the returned reader closes the decoders
+// but not r
+func decompress(contentEncoding string, r io.Reader) (io.ReadCloser, error) {
+	d := &decoder{Reader: r}
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		name := strings.TrimSpace(codings[i])
+		if name == "" || strings.EqualFold(name, "identity") {
+			continue
+		}
+		enc, ok := getEncoding(name)
+		if !ok || enc.NewReader == nil {
+			d.Close()
+			return nil, fmt.Errorf("unsupported content encoding %s", name)
+		}
+		rc, err := enc.NewReader(d.Reader)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.Reader = rc
+		d.closers = append(d.closers, rc)
+	}
+	return d, nil
+}
+
+// decoder reads through a chain of decoders, some of them (e.g: zstd) hold
+// resources until closed
+type decoder struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes the decoders, outermost first
+func (d *decoder) Close() error {
+	var err error
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if cerr := d.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	d.closers = nil
+	return err
+}
@@ -0,0 +1,280 @@
+package http
+
+import (
+	"container/list"
+	"context"
+	"math"
+	nethttp "net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// Strategy decides the order in which mirrors listed in Config.BaseURLs are
+// tried
+type Strategy int
+
+const (
+	// RoundRobin rotates the starting mirror on every request
+	RoundRobin Strategy = iota
+
+	// LeastLatency prefers the mirror with the lowest observed latency,
+	// mirrors without any sample are tried first so they get measured
+	LeastLatency
+
+	// Failover always starts with the first mirror and only moves to the next
+	// one when the current mirror fails
+	Failover
+)
+
+// defaultHedgeDelay is used when hedging is enabled but neither
+// Config.HedgeDelay is set nor enough latency samples have been collected to
+// compute a p95
+const defaultHedgeDelay = 500 * time.Millisecond
+
+// minHedgeSamples is the number of samples required before the observed p95
+// is trusted as hedge delay
+const minHedgeSamples = 20
+
+// latencyWindow is the number of latest samples kept per mirror
+const latencyWindow = 64
+
+// maxEndpointGroups is the number of endpoint groups kept in groupMap, the
+// least recently used ones are evicted first
+const maxEndpointGroups = 256
+
+// groupMap caches endpoint groups by their list of base URLs, so latency
+// statistics and round-robin counter survive between calls
+var groupMap = &endpointGroups{ll: list.New(), items: map[string]*list.Element{}}
+
+// endpointGroups is a LRU cache of endpoint groups, like memoryCache
+type endpointGroups struct {
+	sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// endpointGroup holds the runtime state of a list of mirrors
+type endpointGroup struct {
+	key   string
+	urls  []string
+	next  atomic.Uint64
+	stats []*endpointStat
+}
+
+// endpointStat tracks latency of a single mirror
+type endpointStat struct {
+	sync.Mutex
+	ewma    float64 // exponentially weighted moving average, in nanoseconds
+	samples [latencyWindow]time.Duration
+	n       int // total number of samples recorded
+}
+
+func getEndpointGroup(urls []string) *endpointGroup {
+	key := strings.Join(urls, "\n")
+	groupMap.Lock()
+	defer groupMap.Unlock()
+	if el, ok := groupMap.items[key]; ok {
+		groupMap.ll.MoveToFront(el)
+		return el.Value.(*endpointGroup)
+	}
+
+	// the caller may reuse its slice
+	g := &endpointGroup{key: key, urls: append([]string(nil), urls...), stats: make([]*endpointStat, len(urls))}
+	for i := range g.stats {
+		g.stats[i] = &endpointStat{}
+	}
+	groupMap.items[key] = groupMap.ll.PushFront(g)
+	if groupMap.ll.Len() > maxEndpointGroups {
+		oldest := groupMap.ll.Back()
+		groupMap.ll.Remove(oldest)
+		delete(groupMap.items, oldest.Value.(*endpointGroup).key)
+	}
+	return g
+}
+
+// order returns indexes of mirrors in the order they should be tried
+func (g *endpointGroup) order(strategy Strategy) []int {
+	n := len(g.urls)
+	out := make([]int, n)
+	switch strategy {
+	case Failover:
+		for i := range out {
+			out[i] = i
+		}
+	case LeastLatency:
+		ewma := make([]float64, n)
+		for i := range out {
+			out[i] = i
+			ewma[i] = g.stats[i].latency()
+		}
+		sort.SliceStable(out, func(a, b int) bool { return ewma[out[a]] < ewma[out[b]] })
+	default: // RoundRobin
+		start := int((g.next.Add(1) - 1) % uint64(n))
+		for i := range out {
+			out[i] = (start + i) % n
+		}
+	}
+	return out
+}
+
+// p95 returns the 95th percentile latency across all mirrors of the group,
+// ok is false when there are not enough samples yet
+func (g *endpointGroup) p95() (time.Duration, bool) {
+	var all []time.Duration
+	for _, st := range g.stats {
+		st.Lock()
+		n := st.n
+		if n > latencyWindow {
+			n = latencyWindow
+		}
+		all = append(all, st.samples[:n]...)
+		st.Unlock()
+	}
+	if len(all) < minHedgeSamples {
+		return 0, false
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return all[int(math.Ceil(float64(len(all))*0.95))-1], true
+}
+
+func (st *endpointStat) latency() float64 {
+	st.Lock()
+	defer st.Unlock()
+	return st.ewma
+}
+
+// record adds a latency sample, failed calls are penalized so the mirror
+// drops behind healthy ones under LeastLatency
+func (st *endpointStat) record(d time.Duration, failed bool) {
+	st.Lock()
+	defer st.Unlock()
+	if failed {
+		d = 2*time.Duration(st.ewma) + time.Second
+	} else {
+		st.samples[st.n%latencyWindow] = d
+		st.n++
+	}
+	if st.ewma == 0 {
+		st.ewma = float64(d)
+		return
+	}
+	st.ewma = 0.8*st.ewma + 0.2*float64(d)
+}
+
+// isIdempotent tells whether method is safe to be sent more than once, see
+// RFC 9110 section 9.2.2
+func isIdempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// shouldFailover tells whether the result of a call to one mirror is bad
+// enough to try the next one: network errors, 429 or 5xx
+func shouldFailover(code int) bool {
+	return code == 0 || code == -5 || code == 429 || Is5xx(code)
+}
+
+// joinURL appends path to base, making sure there is exactly one slash
+// between them
+func joinURL(base, path string) string {
+	if path == "" {
+		return base
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+type result struct {
+	body   []byte
+	code   int
+	header nethttp.Header
+	url    string // of the mirror which answered
+}
+
+// sendMirrors sends the request to mirrors listed in config.BaseURLs, path is
+// appended to the base URL of each mirror
+func (me *Client) sendMirrors(ctx context.Context, method, path string, header map[string]string, body []byte, config *Config) result {
+	g := getEndpointGroup(config.BaseURLs)
+	order := g.order(config.Strategy)
+	if config.Hedge && len(order) > 1 && isIdempotent(method) {
+		return me.sendHedged(ctx, g, order, method, path, header, body, config.HedgeDelay)
+	}
+
+	var res result
+	for _, i := range order {
+		res = me.sendMirror(ctx, g, i, method, path, header, body)
+		if !shouldFailover(res.code) {
+			break
+		}
+	}
+	return res
+}
+
+func (me *Client) sendMirror(ctx context.Context, g *endpointGroup, i int, method, path string, header map[string]string, body []byte) result {
+	clk := clock.OrReal(me.Clock)
+	start := clk.Now()
+	url := joinURL(g.urls[i], path)
+	out, code, respheader := me.attempt(ctx, method, url, header, body)
+	if ctx.Err() == nil { // canceled hedges tell nothing about the mirror
+		g.stats[i].record(clk.Now().Sub(start), shouldFailover(code))
+	}
+	return result{body: out, code: code, header: respheader, url: url}
+}
+
+// sendHedged sends the request to the first mirror, then to the next one
+// whenever the hedge delay elapses or an in-flight call fails. The first
+// successful response wins and the other in-flight calls are canceled.
+func (me *Client) sendHedged(ctx context.Context, g *endpointGroup, order []int, method, path string, header map[string]string, body []byte, delay time.Duration) result {
+	if delay <= 0 {
+		var ok bool
+		if delay, ok = g.p95(); !ok {
+			delay = defaultHedgeDelay
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(order))
+	launch := func(i int) {
+		go func() { results <- me.sendMirror(ctx, g, i, method, path, header, body) }()
+	}
+
+	timer := clock.OrReal(me.Clock).NewTimer(delay)
+	defer timer.Stop()
+
+	launch(order[0])
+	sent, received := 1, 0
+	var last result
+	for received < len(order) {
+		select {
+		case <-timer.C():
+			if sent < len(order) {
+				launch(order[sent])
+				sent++
+				timer.Reset(delay)
+			}
+		case res := <-results:
+			received++
+			if !shouldFailover(res.code) {
+				return res
+			}
+			last = res
+			if sent < len(order) {
+				launch(order[sent])
+				sent++
+				timer.Reset(delay)
+			} else if received == sent {
+				return last
+			}
+		}
+	}
+	return last
+}
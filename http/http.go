@@ -1,28 +1,33 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
-	"errors"
+	"context"
 	"io"
 	nethttp "net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/subiz/goutils/clock"
 )
 
 var clientPool = sync.Pool{
 	New: func() any {
-		var t = nethttp.DefaultTransport.(*nethttp.Transport).Clone()
-		t.MaxIdleConns = 200
-		t.MaxConnsPerHost = 200
-		t.MaxIdleConnsPerHost = 200
-		return &Client{
-			HttpClient: &nethttp.Client{
-				Timeout:   120 * time.Second,
-				Transport: t,
-			},
-		}
+		// default transport config is always valid
+		client, _ := NewClientWithTransport(defaultTransportConfig)
+		return client
+	},
+}
+
+var safeClientPool = sync.Pool{
+	New: func() any {
+		tc := *defaultTransportConfig
+		tc.SafeDial = true
+		client, _ := NewClientWithTransport(&tc)
+		return client
 	},
 }
 
@@ -35,11 +40,67 @@ type Config struct {
 	// maximum amount of time wait for the request to complete, included retry time
 	// each call to server only wait for 60 secs
 	Timeout time.Duration
+
+	// list of mirrors serving the same API, e.g: "https://api1.subiz.com/v1".
+	// When set, url passed to Request is treated as a path relative to the
+	// mirror picked by Strategy. A mirror that fails (network error, 429 or
+	// 5xx) is skipped in favor of the next one before backing off
+	BaseURLs []string
+
+	// how mirrors in BaseURLs are picked, default is RoundRobin
+	Strategy Strategy
+
+	// sends a duplicate request to the next mirror when the previous one has
+	// not responded after HedgeDelay, the first success wins. Only applied to
+	// idempotent methods (GET, HEAD, OPTIONS, TRACE, PUT, DELETE)
+	Hedge bool
+
+	// how long to wait before hedging, default to the observed p95 latency of
+	// the mirrors
+	HedgeDelay time.Duration
+
+	// stores responses of GET requests and serves them back while fresh
+	// following their Cache-Control, Expires, ETag and Last-Modified headers
+	// (RFC 9111). When nil, every request is sent with Cache-Control: no-cache.
+	// See NewMemoryCache and NewDiskCache.
+	Cache Cache
+
+	// content coding used to compress request bodies larger than
+	// CompressMinSize, e.g: "gzip". Codings other than gzip and deflate (br,
+	// zstd) must be registered first with RegisterEncoding
+	Compression string
+
+	// minimum body size in bytes to be compressed, default 1024
+	CompressMinSize int
+
+	// name of the client profile registered with RegisterProfile to send the
+	// request with. Only used by package level functions (Request, Get, ...)
+	Profile string
+
+	// sends the request with a client refusing to connect to internal
+	// addresses, see TransportConfig.SafeDial. Set it when the url comes from
+	// users. Only used by package level functions (Request, Get, ...), with
+	// Profile the profile's transport is used with SafeDial forced
+	Safe bool
+
+	// authorizes the request with an OAuth2 token, see NewAuth. When the
+	// server answers 401, the token is refreshed and the request is retried
+	// once
+	Auth *Auth
 }
 
 // which provide simpler syntax and exponential backoff retries.
 type Client struct {
 	HttpClient *nethttp.Client
+
+	// receive spans and measurements of calls made by the client, default to
+	// the ones set by SetTelemetry
+	Tracer  Tracer
+	Metrics Metrics
+
+	// tells the time and waits between retries, default to clock.Real.
+	// Tests may use a clock.FakeClock.
+	Clock clock.Clock
 }
 
 func NewClient() *Client {
@@ -54,51 +115,189 @@ func NewClient() *Client {
 // If success, this method returns raw response body, an ErrNot200 is returned
 // if the server don't return 2xx code.
 func (me *Client) Request(method, url string, body []byte, config *Config) ([]byte, int, nethttp.Header) {
-	var header map[string]string
+	return me.RequestContext(context.Background(), method, url, body, config)
+}
+
+// RequestContext is like Request but stops retrying and aborts in-flight
+// calls as soon as ctx is done. Requests are sent as children of the trace
+// context carried by ctx, see ContextWithTrace.
+func (me *Client) RequestContext(ctx context.Context, method, url string, body []byte, config *Config) ([]byte, int, nethttp.Header) {
+	ctx, _, span := me.startSpan(ctx, "HTTP "+method, method, url)
+	out, code, respheader, sent := me.request(ctx, method, url, body, config)
+	if span != nil && sent != "" && sent != url {
+		// the mirror which answered
+		span.SetAttribute("url.full", sent)
+		span.SetAttribute("server.address", hostOf(sent))
+	}
+	endSpan(span, code, out)
+	return out, code, respheader
+}
+
+// request returns the response and the URL it is sent to, which is empty
+// when it is not sent, e.g: answered from the cache
+func (me *Client) request(ctx context.Context, method, url string, body []byte, config *Config) ([]byte, int, nethttp.Header, string) {
 	timeout := 5 * time.Minute
-	if config != nil {
-		header = config.Header
-		if config.Timeout > 0 {
-			timeout = config.Timeout
+	if config != nil && config.Timeout > 0 {
+		timeout = config.Timeout
+	}
+	if config == nil {
+		config = &Config{}
+	}
+
+	header := make(map[string]string, len(config.Header)+4)
+	for k, v := range config.Header {
+		header[nethttp.CanonicalHeaderKey(k)] = v
+	}
+	header["User-Agent"] = "Subiz-Gun/4.016"
+	header["Connection"] = "keep-alive"
+	if config.Cache == nil {
+		header["Cache-Control"] = "no-cache"
+	}
+
+	if config.Compression != "" && body != nil {
+		minsize := config.CompressMinSize
+		if minsize <= 0 {
+			minsize = defaultCompressMinSize
+		}
+		if len(body) >= minsize {
+			var err error
+			if body, err = compress(config.Compression, body); err != nil {
+				return []byte(err.Error()), -1, nil, ""
+			}
+			header["Content-Encoding"] = config.Compression
+		}
+	}
+
+	var sent string
+	send := func(header map[string]string) ([]byte, int, nethttp.Header) {
+		var out []byte
+		var code int
+		var respheader nethttp.Header
+		out, code, respheader, sent = me.retry(ctx, method, url, header, body, config, timeout)
+		return out, code, respheader
+	}
+	if config.Auth != nil {
+		send = me.withAuth(ctx, config.Auth, send)
+	}
+	if config.Cache != nil {
+		key := url
+		if len(config.BaseURLs) > 0 {
+			key = strings.Join(config.BaseURLs, ",") + " " + url
+		}
+		out, code, respheader := me.sendCached(key, send, method, header, config.Cache)
+		return out, code, respheader, sent
+	}
+	out, code, respheader := send(header)
+	return out, code, respheader, sent
+}
+
+// withAuth wraps send to add the Authorization header, refreshing the token
+// and retrying once on 401
+func (me *Client) withAuth(ctx context.Context, auth *Auth, send func(map[string]string) ([]byte, int, nethttp.Header)) func(map[string]string) ([]byte, int, nethttp.Header) {
+	return func(header map[string]string) ([]byte, int, nethttp.Header) {
+		var out []byte
+		var code int
+		var respheader nethttp.Header
+		for attempt := 0; attempt < 2; attempt++ {
+			token, err := auth.Token(ctx)
+			if err != nil {
+				return []byte(err.Error()), -1, nil
+			}
+			authheader := make(map[string]string, len(header)+1)
+			for k, v := range header {
+				authheader[k] = v
+			}
+			authheader["Authorization"] = token.header()
+			if out, code, respheader = send(authheader); code != 401 {
+				break
+			}
+			auth.Invalidate(token)
 		}
+		return out, code, respheader
 	}
+}
+
+// retry sends the request until it succeeds or timeout, retrying on 429 and
+// 5xx with exponential backoff. It also returns the URL of the last attempt,
+// which is resolved against the mirror when config.BaseURLs is set.
+func (me *Client) retry(ctx context.Context, method, url string, header map[string]string, body []byte, config *Config, timeout time.Duration) ([]byte, int, nethttp.Header, string) {
 	var out []byte     // raw response body
 	var statuscode int // returned status code, -1 indicates internal error
 	var respheader nethttp.Header
+	sent := url
+
+	clk := clock.OrReal(me.Clock)
 
 	// create backoff utility to do retry
 	bo := backoff.NewExponentialBackOff()
 	bo.MaxInterval = 60 * time.Second
 	bo.MaxElapsedTime = timeout
+	bo.Clock = clk
 	bo.Reset()
 
-	err := backoff.Retry(func() error {
-		out, statuscode, respheader = sendHTTP(me.HttpClient, method, url, header, body)
+	var timer clock.Timer
+	for {
+		if len(config.BaseURLs) > 0 {
+			res := me.sendMirrors(ctx, method, url, header, body, config)
+			out, statuscode, respheader, sent = res.body, res.code, res.header, res.url
+		} else {
+			out, statuscode, respheader = me.attempt(ctx, method, url, header, body)
+		}
+		// we don't retry on other status code (400, 300)
+		if statuscode != 429 && !Is5xx(statuscode) {
+			return out, statuscode, respheader, sent
+		}
+
 		// retry on 429 or 5xx
-		if statuscode == 429 || Is5xx(statuscode) {
-			return errors.New("retry")
+		next := bo.NextBackOff()
+		if next == backoff.Stop || ctx.Err() != nil {
+			return out, -2, respheader, sent
+		}
+		if metrics := me.metrics(); metrics != nil {
+			metrics.IncRetry(hostOf(sent), method)
+		}
+		if timer == nil {
+			timer = clk.NewTimer(next)
+			defer timer.Stop()
+		} else {
+			timer.Reset(next)
 		}
+		select {
+		case <-ctx.Done():
+			return out, -2, respheader, sent
+		case <-timer.C():
+		}
+	}
+}
 
-		// we don't retry on other status code (400, 300)
-		return nil
-	}, bo)
-	if err != nil {
-		return out, -2, respheader
+// attempt calls sendHTTP once, reporting a span and metrics and propagating
+// the trace context in the traceparent header
+func (me *Client) attempt(ctx context.Context, method, url string, header map[string]string, body []byte) ([]byte, int, nethttp.Header) {
+	ctx, tc, span := me.startSpan(ctx, "HTTP "+method+" attempt", method, url)
+	tracedheader := make(map[string]string, len(header)+1)
+	for k, v := range header {
+		tracedheader[k] = v
 	}
+	tracedheader["Traceparent"] = tc.String()
 
-	return out, statuscode, respheader
+	clk := clock.OrReal(me.Clock)
+	start := clk.Now()
+	out, code, respheader := sendHTTP(ctx, me.HttpClient, method, url, tracedheader, body)
+	me.observeAttempt(method, url, code, clk.Now().Sub(start), len(body), out)
+	endSpan(span, code, out)
+	return out, code, respheader
 }
 
 // sendHTTP make an http request to http endpoint
 // method, url must not be empty
 // this method returns (response body in []byte, status code, and an error)
-func sendHTTP(client *nethttp.Client, method, url string, header map[string]string, body []byte) ([]byte, int, nethttp.Header) {
+func sendHTTP(ctx context.Context, client *nethttp.Client, method, url string, header map[string]string, body []byte) ([]byte, int, nethttp.Header) {
 	var req *nethttp.Request
 	var err error
 	if body == nil {
-		req, err = nethttp.NewRequest(method, url, nil)
+		req, err = nethttp.NewRequestWithContext(ctx, method, url, nil)
 	} else {
-		req, err = nethttp.NewRequest(method, url, bytes.NewReader(body))
+		req, err = nethttp.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 	}
 	if err != nil {
 		return []byte(err.Error()), -1, nil
@@ -107,10 +306,13 @@ func sendHTTP(client *nethttp.Client, method, url string, header map[string]stri
 	for k, v := range header {
 		req.Header.Set(k, v)
 	}
-
-	req.Header.Set("User-Agent", "Subiz-Gun/4.016")
-	req.Header.Set("Cache-Control", "no-cache")
-	req.Header.Set("Connection", "keep-alive")
+	// like the transport does for gzip, responses are only decoded when we
+	// asked for the encoding, not when the caller did
+	decode := false
+	if customEncoding.Load() && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding())
+		decode = true
+	}
 
 	res, err := client.Do(req)
 	if err != nil {
@@ -118,7 +320,25 @@ func sendHTTP(client *nethttp.Client, method, url string, header map[string]stri
 	}
 
 	defer res.Body.Close()
-	b, err := io.ReadAll(res.Body)
+	var r io.Reader = res.Body
+	if decode && res.Header.Get("Content-Encoding") != "" {
+		// responses to HEAD, 204, 304 and empty bodies carry the header
+		// but nothing to decode
+		br := bufio.NewReader(res.Body)
+		if _, err := br.Peek(1); err == io.EOF {
+			r = br
+		} else {
+			dr, err := decompress(res.Header.Get("Content-Encoding"), br)
+			if err != nil {
+				return []byte(err.Error()), -5, nil
+			}
+			defer dr.Close()
+			r = dr
+			res.Header.Del("Content-Encoding")
+			res.Header.Del("Content-Length")
+		}
+	}
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return []byte(err.Error()), -5, nil
 	}
@@ -142,9 +362,28 @@ func Is5xx(code int) bool { return 499 < code && code < 600 }
 // If success, this method returns raw response body, an ErrNot200 is returned if
 // the server don't return 2xx code.
 func Request(method, url string, body []byte, config *Config) ([]byte, int, nethttp.Header) {
-	client := clientPool.Get().(*Client)
+	if config != nil && config.Profile != "" {
+		if config.Safe {
+			client, err := getSafeProfile(config.Profile)
+			if err != nil {
+				return []byte(err.Error()), -1, nil
+			}
+			return client.Request(method, url, body, config)
+		}
+		client, ok := GetProfile(config.Profile)
+		if !ok {
+			return []byte("unknown profile " + config.Profile), -1, nil
+		}
+		return client.Request(method, url, body, config)
+	}
+
+	pool := &clientPool
+	if config != nil && config.Safe {
+		pool = &safeClientPool
+	}
+	client := pool.Get().(*Client)
 	defer func() {
-		clientPool.Put(client)
+		pool.Put(client)
 	}()
 	return client.Request(method, url, body, config)
 }
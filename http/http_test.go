@@ -0,0 +1,736 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+func TestFailover(t *testing.T) {
+	down := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(503)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer up.Close()
+
+	out, code, _ := NewClient().Request("GET", "/users/1", nil, &Config{
+		BaseURLs: []string{down.URL + "/v1", up.URL + "/v1/"},
+		Strategy: Failover,
+	})
+	if code != 200 || string(out) != "/v1/users/1" {
+		t.Errorf("should be 200 /v1/users/1, got %d %s", code, out)
+	}
+
+	// groups keep their own copy of the urls and are bounded
+	urls := []string{"http://a", "http://b"}
+	g := getEndpointGroup(urls)
+	urls[0] = "http://c"
+	if g.urls[0] != "http://a" || getEndpointGroup([]string{"http://a", "http://b"}) != g {
+		t.Errorf("should not share the caller's slice, got %v", g.urls)
+	}
+	for i := 0; i < 2*maxEndpointGroups; i++ {
+		getEndpointGroup([]string{"http://" + strconv.Itoa(i)})
+	}
+	if n := groupMap.ll.Len(); n != maxEndpointGroups || getEndpointGroup([]string{"http://a", "http://b"}) == g {
+		t.Errorf("should evict the least recently used groups, got %d groups", n)
+	}
+}
+
+func TestRetryClock(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if hits.Add(1) < 3 || r.URL.Path == "/down" {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// advances the fake clock whenever the client waits before a retry
+	c := clock.NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				if c.Waiters() > 0 {
+					c.Advance(time.Minute)
+				}
+			}
+		}
+	}()
+
+	client := NewClient()
+	client.Clock = c
+	start := time.Now()
+	out, code, _ := client.Request("GET", server.URL, nil, nil)
+	if code != 200 || string(out) != "ok" || hits.Load() != 3 {
+		t.Errorf("should succeed on the third attempt, got %d %s after %d hits", code, out, hits.Load())
+	}
+
+	// gives up once the timeout elapsed on the fake clock
+	_, code, _ = client.Request("GET", server.URL+"/down", nil, &Config{Timeout: 10 * time.Minute})
+	if code != -2 {
+		t.Errorf("should time out, got %d", code)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("should not wait for real, took %v", time.Since(start))
+	}
+}
+
+func TestHedge(t *testing.T) {
+	slow := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	config := &Config{
+		BaseURLs:   []string{slow.URL, fast.URL},
+		Strategy:   Failover,
+		Hedge:      true,
+		HedgeDelay: 50 * time.Millisecond,
+	}
+	start := time.Now()
+	out, code, _ := NewClient().Request("GET", "/", nil, config)
+	if code != 200 || string(out) != "fast" {
+		t.Errorf("should be 200 fast, got %d %s", code, out)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("hedged request took too long: %v", d)
+	}
+
+	// POST is not idempotent, must wait for the slow mirror
+	out, _, _ = NewClient().Request("POST", "/", nil, config)
+	if string(out) != "slow" {
+		t.Errorf("should not hedge POST, got %s", out)
+	}
+}
+
+func TestCache(t *testing.T) {
+	var hits, notmodified int
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		hits++
+		if r.Header.Get("Cache-Control") == "no-cache" {
+			t.Errorf("should not send no-cache when caching")
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=0")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notmodified++
+			w.WriteHeader(304)
+			return
+		}
+		w.Write([]byte("config"))
+	}))
+	defer server.Close()
+
+	config := &Config{Cache: NewMemoryCache(10)}
+	for i := 0; i < 3; i++ {
+		out, code, _ := NewClient().Request("GET", server.URL, nil, config)
+		if code != 200 || string(out) != "config" {
+			t.Fatalf("%d: should be 200 config, got %d %s", i, code, out)
+		}
+	}
+	if hits != 3 || notmodified != 2 {
+		t.Errorf("should revalidate twice, got %d hits, %d not modified", hits, notmodified)
+	}
+
+	// fresh response must not hit the server
+	hits = 0
+	fresh := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("fresh"))
+	}))
+	defer fresh.Close()
+	for i := 0; i < 3; i++ {
+		if out, _, _ := NewClient().Request("GET", fresh.URL, nil, config); string(out) != "fresh" {
+			t.Fatalf("should be fresh, got %s", out)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("should hit server once, got %d", hits)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			body, _ = gzip.NewReader(r.Body)
+		}
+		b, _ := io.ReadAll(body)
+		w.Write([]byte(r.Header.Get("Content-Encoding") + ":" + strconv.Itoa(len(b))))
+	}))
+	defer server.Close()
+
+	big := bytes.Repeat([]byte("a"), 4096)
+	out, _, _ := NewClient().Request("POST", server.URL, big, &Config{Compression: "gzip"})
+	if string(out) != "gzip:4096" {
+		t.Errorf("should be gzip:4096, got %s", out)
+	}
+
+	out, _, _ = NewClient().Request("POST", server.URL, []byte("small"), &Config{Compression: "gzip"})
+	if string(out) != ":5" {
+		t.Errorf("should not compress small body, got %s", out)
+	}
+}
+
+func TestDecompression(t *testing.T) {
+	var closed int
+	RegisterEncoding("x-test", &Encoding{
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return &closeCounter{ReadCloser: zr, n: &closed}, nil
+		},
+	})
+	defer func() {
+		encodingMap.Delete("x-test")
+		customEncoding.Store(false)
+	}()
+
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch r.URL.Path {
+		case "/accept":
+			w.Write([]byte(r.Header.Get("Accept-Encoding")))
+		case "/empty":
+			w.Header().Set("Content-Encoding", "x-test")
+			w.WriteHeader(204)
+		default:
+			w.Header().Set("Content-Encoding", "x-test")
+			if r.Method == "HEAD" {
+				return
+			}
+			zw := gzip.NewWriter(w)
+			zw.Write([]byte("hello"))
+			zw.Close()
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient().HttpClient
+	out, code, _ := sendHTTP(context.Background(), client, "GET", server.URL, nil, nil)
+	if code != 200 || string(out) != "hello" {
+		t.Errorf("should be 200 hello, got %d %s", code, out)
+	}
+	if closed != 1 {
+		t.Errorf("should close the decoder, got %d", closed)
+	}
+
+	for _, method := range []string{"HEAD", "GET"} {
+		url := server.URL
+		if method == "GET" {
+			url += "/empty"
+		}
+		_, code, _ = sendHTTP(context.Background(), client, method, url, nil, nil)
+		if code != 200 && code != 204 {
+			t.Errorf("%s %s: should not decode an empty body, got %d", method, url, code)
+		}
+	}
+
+	out, _, _ = sendHTTP(context.Background(), client, "GET", server.URL+"/accept", nil, nil)
+	if !strings.Contains(string(out), "x-test") {
+		t.Errorf("should accept x-test, got %s", out)
+	}
+	out, _, _ = sendHTTP(context.Background(), client, "GET", server.URL+"/accept", map[string]string{"Accept-Encoding": "identity"}, nil)
+	if string(out) != "identity" {
+		t.Errorf("should keep the caller's Accept-Encoding, got %s", out)
+	}
+}
+
+type closeCounter struct {
+	io.ReadCloser
+	n *int
+}
+
+func (c *closeCounter) Close() error {
+	*c.n++
+	return c.ReadCloser.Close()
+}
+
+func TestProfile(t *testing.T) {
+	server := httptest.NewTLSServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	capem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err := RegisterProfile("internal", &TransportConfig{
+		CAPEM: capem,
+		DNS:   map[string]string{"example.com": "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "https://example.com:" + port + "/"
+	out, code, _ := Request("GET", url, nil, &Config{Profile: "internal"})
+	if code != 200 || string(out) != "example.com:"+port {
+		t.Errorf("should be 200 example.com, got %d %s", code, out)
+	}
+
+	if _, code, _ = Request("GET", url, nil, &Config{Profile: "public"}); code != -1 {
+		t.Errorf("should fail on unknown profile, got %d", code)
+	}
+
+	// safe requests don't bypass safe dial through a profile
+	out, code, _ = Request("GET", url, nil, &Config{Profile: "internal", Safe: true})
+	if code != 0 || !strings.Contains(string(out), ErrBlockedAddress.Error()) {
+		t.Errorf("should block loopback, got %d %s", code, out)
+	}
+	if err := RegisterProfile("proxied", &TransportConfig{Proxy: "http://10.0.0.1:3128"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, code, _ = Request("GET", url, nil, &Config{Profile: "proxied", Safe: true}); code != -1 {
+		t.Errorf("should refuse safe requests through a proxy, got %d", code)
+	}
+}
+
+func TestSafeDial(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/redirect" {
+			nethttp.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", 302)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	out, code, _ := Request("GET", server.URL, nil, &Config{Safe: true})
+	if code != 0 || !strings.Contains(string(out), ErrBlockedAddress.Error()) {
+		t.Errorf("should block loopback, got %d %s", code, out)
+	}
+
+	// host names resolving to internal addresses are blocked too
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	out, code, _ = Request("GET", "http://localhost:"+port, nil, &Config{Safe: true})
+	if code != 0 || !strings.Contains(string(out), ErrBlockedAddress.Error()) {
+		t.Errorf("should block localhost, got %d %s", code, out)
+	}
+
+	client, err := NewClientWithTransport(&TransportConfig{SafeDial: true, AllowCIDRs: []string{"127.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, code, _ = client.Request("GET", server.URL, nil, nil); code != 200 {
+		t.Errorf("should allow 127.0.0.0/8, got %d %s", code, out)
+	}
+
+	out, code, _ = client.Request("GET", server.URL+"/redirect", nil, nil)
+	if code != 0 || !strings.Contains(string(out), ErrBlockedAddress.Error()) {
+		t.Errorf("should block redirect to metadata, got %d %s", code, out)
+	}
+}
+
+func TestIsBlockedIP(t *testing.T) {
+	tcs := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"10.1.2.3", true},
+		{"172.31.255.255", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"100.100.100.200", true},
+		{"::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"fd00:ec2::254", true},
+		{"fe80::1", true},
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+	}
+	for _, tc := range tcs {
+		if blocked := IsBlockedIP(netip.MustParseAddr(tc.ip)); blocked != tc.blocked {
+			t.Errorf("%s: should be %v, got %v", tc.ip, tc.blocked, blocked)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	var conns int
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		conns++
+		switch conns {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(": hello\nretry: 10\n\nid: 1\nevent: greet\ndata: hi\ndata: there\n\nid: 2\r\ndata: second\r\n\r\ndata: incomplete"))
+		case 2:
+			if id := r.Header.Get("Last-Event-ID"); id != "2" {
+				t.Errorf("should resume from 2, got %s", id)
+			}
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			w.Write([]byte("id: 3\ndata: third\n\n"))
+		default:
+			w.WriteHeader(204)
+		}
+	}))
+	defer server.Close()
+
+	var events []*Event
+	err := NewClient().Stream(context.Background(), server.URL, nil, func(ev *Event) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Event{
+		{ID: "1", Event: "greet", Data: "hi\nthere"},
+		{ID: "2", Event: "message", Data: "second"},
+		{ID: "3", Event: "message", Data: "third"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("should receive %d events, got %d", len(expected), len(events))
+	}
+	for i, ev := range events {
+		if *ev != expected[i] {
+			t.Errorf("%d: should be %v, got %v", i, expected[i], *ev)
+		}
+	}
+}
+
+func TestEventsCancel(t *testing.T) {
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; ; i++ {
+			if _, err := w.Write([]byte("data: " + strconv.Itoa(i) + "\n\n")); err != nil {
+				return
+			}
+			w.(nethttp.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, errc := NewClient().Events(ctx, server.URL, nil)
+	for ev := range events {
+		if ev.Data == "2" {
+			cancel()
+			break
+		}
+	}
+	for range events {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("should be canceled, got %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var fetches atomic.Int32
+	tokenServer := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		n := fetches.Add(1)
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "client" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"access_token":"t` + strconv.Itoa(int(n)) + `","token_type":"bearer","expires_in":"3600"}`))
+	}))
+	defer tokenServer.Close()
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		// the first token is revoked
+		if r.Header.Get("Authorization") != "Bearer t2" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer api.Close()
+
+	auth := NewAuth(&ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client", ClientSecret: "s3cret"})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := auth.Token(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("should fetch once, got %d", n)
+	}
+
+	out, code, _ := NewClient().Request("GET", api.URL, nil, &Config{Auth: auth})
+	if code != 200 || string(out) != "ok" {
+		t.Errorf("should retry with a new token, got %d %s", code, out)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("should fetch twice, got %d", n)
+	}
+	file := &FormFile{FieldName: "f", FileName: "a.txt", Open: func() (io.Reader, error) { return strings.NewReader("a"), nil }}
+	out, code, _ = NewClient().Upload(context.Background(), "POST", api.URL, nil, []*FormFile{file}, &Config{Auth: auth}, nil)
+	if code != 200 || string(out) != "ok" {
+		t.Errorf("should authorize uploads, got %d %s", code, out)
+	}
+
+	bad := NewAuth(&ClientCredentials{TokenURL: tokenServer.URL, ClientID: "client"})
+	if _, err := bad.Token(context.Background()); err == nil || err.(*TokenError).Code != "invalid_client" {
+		t.Errorf("should be invalid_client, got %v", err)
+	}
+}
+
+type testSpan struct {
+	name   string
+	tc     TraceContext
+	parent TraceContext
+	attrs  map[string]any
+}
+
+func (s *testSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+func (s *testSpan) End(err error)                      {}
+
+type testTelemetry struct {
+	sync.Mutex
+	spans    []*testSpan
+	attempts []string
+	retries  int
+	hosts    []string // of attempts and retries
+}
+
+func (tt *testTelemetry) Start(ctx context.Context, name string, tc, parent TraceContext) Span {
+	tt.Lock()
+	defer tt.Unlock()
+	span := &testSpan{name: name, tc: tc, parent: parent, attrs: map[string]any{}}
+	tt.spans = append(tt.spans, span)
+	return span
+}
+
+func (tt *testTelemetry) ObserveAttempt(host, method, class string, latency time.Duration, out, in int) {
+	tt.Lock()
+	defer tt.Unlock()
+	tt.attempts = append(tt.attempts, method+" "+class+" "+strconv.Itoa(out)+" "+strconv.Itoa(in))
+	tt.hosts = append(tt.hosts, host)
+}
+
+func (tt *testTelemetry) IncRetry(host, method string) {
+	tt.Lock()
+	defer tt.Unlock()
+	tt.retries++
+	tt.hosts = append(tt.hosts, host)
+}
+
+func TestTelemetry(t *testing.T) {
+	var calls int
+	var traceparents []string
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		calls++
+		traceparents = append(traceparents, r.Header.Get("traceparent"))
+		if calls == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+
+	parent, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt := &testTelemetry{}
+	client := NewClient()
+	client.Tracer, client.Metrics = tt, tt
+	_, code, _ := client.RequestContext(ContextWithTrace(context.Background(), parent), "POST", server.URL, []byte("ping"), nil)
+	if code != 200 {
+		t.Fatalf("should be 200, got %d", code)
+	}
+
+	if len(tt.spans) != 3 || tt.spans[0].name != "HTTP POST" || tt.spans[0].parent != parent {
+		t.Fatalf("should have a request span and 2 attempt spans, got %d", len(tt.spans))
+	}
+	for i, tp := range traceparents {
+		attempt := tt.spans[i+1]
+		if attempt.parent != tt.spans[0].tc || tp != attempt.tc.String() {
+			t.Errorf("%d: should propagate attempt span, got %s", i, tp)
+		}
+		if attempt.tc.TraceID != parent.TraceID {
+			t.Errorf("%d: should keep trace id", i)
+		}
+	}
+	if tt.retries != 1 || len(tt.attempts) != 2 || tt.attempts[0] != "POST 5xx 4 0" || tt.attempts[1] != "POST 2xx 4 4" {
+		t.Errorf("unexpected metrics %d %v", tt.retries, tt.attempts)
+	}
+
+	// relative URLs are reported with the host of the mirror
+	calls = 0
+	tt = &testTelemetry{}
+	client.Tracer, client.Metrics = tt, tt
+	_, code, _ = client.Request("GET", "/ping", nil, &Config{BaseURLs: []string{server.URL}})
+	if code != 200 {
+		t.Fatalf("should be 200, got %d", code)
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	if strings.Join(tt.hosts, " ") != host+" "+host+" "+host {
+		t.Errorf("should report attempts and retries of %s, got %v", host, tt.hosts)
+	}
+	if addr := tt.spans[0].attrs["server.address"]; addr != host {
+		t.Errorf("should report the mirror of the request span, got %v", addr)
+	}
+
+	// streamed transfers
+	calls = 0
+	tt = &testTelemetry{}
+	client.Tracer, client.Metrics = tt, tt
+	file := &FormFile{FieldName: "f", FileName: "a.txt", Open: func() (io.Reader, error) { return strings.NewReader("a"), nil }}
+	if _, code, _ := client.Upload(context.Background(), "POST", server.URL, nil, []*FormFile{file}, nil, nil); code != 200 {
+		t.Fatalf("should upload, got %d", code)
+	}
+	calls = 0
+	if _, err := client.Download(context.Background(), server.URL, io.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(tt.spans) != 6 || tt.spans[0].name != "HTTP POST" || tt.spans[3].name != "HTTP GET" || tt.spans[5].attrs["http.response.status_code"] != 200 {
+		t.Errorf("should have a span and 2 attempt spans by transfer, got %d", len(tt.spans))
+	}
+	if tt.retries != 2 || len(tt.attempts) != 4 || !strings.HasPrefix(tt.attempts[1], "POST 2xx ") || tt.attempts[1] == "POST 2xx 0 4" || tt.attempts[3] != "GET 2xx 0 4" {
+		t.Errorf("unexpected metrics %d %v", tt.retries, tt.attempts)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	sum := sha256.Sum256(content)
+	var ranges []string
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		ranges = append(ranges, r.Header.Get("Range")+"|"+r.Header.Get("If-Range"))
+		w.Header().Set("ETag", `"v1"`)
+		if len(ranges) == 1 {
+			// drop the connection in the middle of the body
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:30000])
+			w.(nethttp.Flusher).Flush()
+			panic(nethttp.ErrAbortHandler)
+		}
+		nethttp.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	var lastdone, lasttotal int64
+	n, err := NewClient().Download(context.Background(), server.URL, &buf, &DownloadOptions{
+		Checksum: "sha256:" + hex.EncodeToString(sum[:]),
+		Progress: func(done, total int64) { lastdone, lasttotal = done, total },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) || !bytes.Equal(buf.Bytes(), content) {
+		t.Fatalf("should download %d bytes, got %d", len(content), n)
+	}
+	if len(ranges) != 2 || ranges[0] != "|" || ranges[1] != `bytes=30000-|"v1"` {
+		t.Errorf("should resume from 30000, got %v", ranges)
+	}
+	if lastdone != n || lasttotal != n {
+		t.Errorf("should report progress %d/%d, got %d/%d", n, n, lastdone, lasttotal)
+	}
+
+	_, err = NewClient().Download(context.Background(), server.URL, io.Discard, &DownloadOptions{Checksum: "sha256:00"})
+	if err != ErrChecksumMismatch {
+		t.Errorf("should be checksum mismatch, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := NewClient().DownloadFile(context.Background(), server.URL, path, nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, content) {
+		t.Errorf("should write the whole content to file, got %d bytes", len(b))
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(503)
+			return
+		}
+		f, header, err := r.FormFile("attachment")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, _ := io.ReadAll(f)
+		w.Write([]byte(r.FormValue("note") + ":" + header.Filename + ":" + string(b)))
+	}))
+	defer server.Close()
+
+	var done int64
+	file := &FormFile{
+		FieldName: "attachment",
+		FileName:  "a.txt",
+		Size:      5,
+		Open:      func() (io.Reader, error) { return strings.NewReader("hello"), nil },
+	}
+	out, code, _ := NewClient().Upload(context.Background(), "POST", server.URL,
+		map[string]string{"note": "hi"}, []*FormFile{file}, nil, func(d, total int64) { done = d })
+	if code != 200 || string(out) != "hi:a.txt:hello" {
+		t.Errorf("should be 200 hi:a.txt:hello, got %d %s", code, out)
+	}
+	if done != 5 {
+		t.Errorf("should report 5 bytes uploaded, got %d", done)
+	}
+
+	// the client timeout doesn't cut a slow upload
+	slow := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer slow.Close()
+	client := NewClient()
+	client.HttpClient.Timeout = 20 * time.Millisecond
+	out, code, _ = client.Upload(context.Background(), "POST", slow.URL, nil, []*FormFile{file}, nil, nil)
+	if code != 200 || string(out) != "ok" {
+		t.Errorf("should be 200 ok, got %d %s", code, out)
+	}
+}
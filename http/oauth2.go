@@ -0,0 +1,287 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+// defaultRefreshBefore is how long before expiry a token is refreshed
+const defaultRefreshBefore = time.Minute
+
+// Token is an OAuth2 access token
+type Token struct {
+	AccessToken  string
+	TokenType    string // default Bearer
+	RefreshToken string
+	Expiry       time.Time // zero means the token never expires
+}
+
+// header returns value for the Authorization header
+func (t *Token) header() string {
+	typ := t.TokenType
+	if typ == "" || strings.EqualFold(typ, "bearer") {
+		typ = "Bearer"
+	}
+	return typ + " " + t.AccessToken
+}
+
+// expiresWithin tells whether the token expires in less than d
+func (t *Token) expiresWithin(now time.Time, d time.Duration) bool {
+	return !t.Expiry.IsZero() && !now.Add(d).Before(t.Expiry)
+}
+
+// TokenFetcher gets a new token from an authorization server
+type TokenFetcher interface {
+	Fetch(ctx context.Context, client *Client) (*Token, error)
+}
+
+// TokenError is returned when the authorization server refuses to issue a
+// token, see RFC 6749 section 5.2
+type TokenError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	Body        string
+}
+
+func (e *TokenError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("oauth2: %d %s: %s", e.StatusCode, e.Code, e.Description)
+	}
+	return fmt.Sprintf("oauth2: %d %s", e.StatusCode, e.Body)
+}
+
+// ClientCredentials fetches tokens using the client credentials grant, see RFC
+// 6749 section 4.4
+type ClientCredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// extra form parameters sent to the token endpoint
+	Params map[string]string
+
+	// sends client_id and client_secret as form parameters instead of basic
+	// auth, needed by some providers
+	AuthInParams bool
+}
+
+func (c *ClientCredentials) Fetch(ctx context.Context, client *Client) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	for k, v := range c.Params {
+		form.Set(k, v)
+	}
+	return fetchToken(ctx, client, c.TokenURL, c.ClientID, c.ClientSecret, c.AuthInParams, form)
+}
+
+// RefreshToken fetches tokens by exchanging a refresh token, see RFC 6749
+// section 6. When the server rotates the refresh token, the new one is kept
+// for the next exchange.
+type RefreshToken struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	RefreshToken string
+
+	// extra form parameters sent to the token endpoint
+	Params map[string]string
+
+	// sends client_id and client_secret as form parameters instead of basic
+	// auth, needed by some providers
+	AuthInParams bool
+
+	// called with the new refresh token when the server rotates it, so it
+	// can be persisted
+	OnRotate func(refreshToken string)
+
+	mu sync.Mutex
+}
+
+func (r *RefreshToken) Fetch(ctx context.Context, client *Client) (*Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {r.RefreshToken}}
+	for k, v := range r.Params {
+		form.Set(k, v)
+	}
+	token, err := fetchToken(ctx, client, r.TokenURL, r.ClientID, r.ClientSecret, r.AuthInParams, form)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != "" && token.RefreshToken != r.RefreshToken {
+		r.RefreshToken = token.RefreshToken
+		if r.OnRotate != nil {
+			r.OnRotate(token.RefreshToken)
+		}
+	}
+	return token, nil
+}
+
+// fetchToken posts form to the token endpoint and parses the token response,
+// see RFC 6749 section 5.1
+func fetchToken(ctx context.Context, client *Client, tokenURL, clientID, clientSecret string, authInParams bool, form url.Values) (*Token, error) {
+	header := map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"Accept":       "application/json",
+	}
+	if authInParams {
+		form.Set("client_id", clientID)
+		if clientSecret != "" {
+			form.Set("client_secret", clientSecret)
+		}
+	} else if clientID != "" {
+		header["Authorization"] = "Basic " + basicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	out, code, _ := client.RequestContext(ctx, "POST", tokenURL, []byte(form.Encode()), &Config{
+		Header:  header,
+		Timeout: 1 * time.Minute,
+	})
+	if !Is2xx(code) {
+		e := &TokenError{StatusCode: code, Body: string(out)}
+		json.Unmarshal(out, e)
+		return nil, e
+	}
+
+	var res struct {
+		AccessToken  string          `json:"access_token"`
+		TokenType    string          `json:"token_type"`
+		RefreshToken string          `json:"refresh_token"`
+		ExpiresIn    json.RawMessage `json:"expires_in"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, err
+	}
+	if res.AccessToken == "" {
+		return nil, &TokenError{StatusCode: code, Body: string(out)}
+	}
+
+	token := &Token{AccessToken: res.AccessToken, TokenType: res.TokenType, RefreshToken: res.RefreshToken}
+	// some providers return expires_in as a string
+	if sec, err := strconv.ParseInt(strings.Trim(string(res.ExpiresIn), `"`), 10, 64); err == nil && sec > 0 {
+		token.Expiry = clock.OrReal(client.Clock).Now().Add(time.Duration(sec) * time.Second)
+	}
+	return token, nil
+}
+
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+// Auth authorizes requests with OAuth2 tokens got from a TokenFetcher. Tokens
+// are cached until they expire and refreshed in the background shortly
+// before, concurrent callers share a single fetch. See Config.Auth.
+type Auth struct {
+	// how long before expiry the token is refreshed, default 1 minute
+	RefreshBefore time.Duration
+
+	// tells whether tokens expired, default to clock.Real
+	Clock clock.Clock
+
+	fetcher TokenFetcher
+	client  *Client
+
+	mu       sync.Mutex
+	token    *Token
+	inflight *tokenFlight
+}
+
+// tokenFlight is a fetch in progress, waiters block on done
+type tokenFlight struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// NewAuth creates an Auth fetching tokens with fetcher, e.g:
+//
+//	auth := http.NewAuth(&http.ClientCredentials{
+//		TokenURL: "https://oauth2.googleapis.com/token",
+//		ClientID: id,
+//		ClientSecret: secret,
+//	})
+//	http.Request("GET", url, nil, &http.Config{Auth: auth})
+func NewAuth(fetcher TokenFetcher) *Auth {
+	return &Auth{fetcher: fetcher, client: NewClient()}
+}
+
+// Token returns a valid token, fetching a new one when the cached token is
+// missing or expired
+func (a *Auth) Token(ctx context.Context) (*Token, error) {
+	refreshBefore := a.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = defaultRefreshBefore
+	}
+
+	now := clock.OrReal(a.Clock).Now()
+	a.mu.Lock()
+	token := a.token
+	if token != nil && !token.expiresWithin(now, 0) {
+		if token.expiresWithin(now, refreshBefore) {
+			// still usable, refresh proactively without blocking the caller
+			a.startFetch()
+		}
+		a.mu.Unlock()
+		return token, nil
+	}
+	flight := a.startFetch()
+	a.mu.Unlock()
+
+	select {
+	case <-flight.done:
+		return flight.token, flight.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// startFetch starts a fetch unless one is already in flight, a.mu must be held
+func (a *Auth) startFetch() *tokenFlight {
+	if a.inflight != nil {
+		return a.inflight
+	}
+	flight := &tokenFlight{done: make(chan struct{})}
+	a.inflight = flight
+	go func() {
+		// the fetch is shared by many callers, it must not be canceled by
+		// the one which happened to start it
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		// tokens expire on the clock of the Auth
+		client := *a.client
+		client.Clock = a.Clock
+		flight.token, flight.err = a.fetcher.Fetch(ctx, &client)
+
+		a.mu.Lock()
+		if flight.err == nil {
+			a.token = flight.token
+		}
+		a.inflight = nil
+		a.mu.Unlock()
+		close(flight.done)
+	}()
+	return flight
+}
+
+// Invalidate drops token from the cache, so the next call to Token fetches a
+// new one. It does nothing if the cache already holds a different token.
+func (a *Auth) Invalidate(token *Token) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == token {
+		a.token = nil
+	}
+}
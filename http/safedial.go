@@ -0,0 +1,146 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// ErrBlockedAddress is returned (wrapped) when a safe client is asked to
+// connect to a private, loopback, link-local or otherwise internal address
+var ErrBlockedAddress = errors.New("blocked address")
+
+// maxRedirects is the number of redirects a safe client follows
+const maxRedirects = 10
+
+// blockedPrefixes lists address ranges which must not be reachable from user
+// supplied URLs, see RFC 6890 for the special purpose registries
+var blockedPrefixes = mustParsePrefixes(
+	"0.0.0.0/8",       // "this" network
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // carrier-grade NAT, also Alibaba Cloud metadata
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local, also AWS/GCP/Azure metadata
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, also broadcast
+	"::/128",          // unspecified
+	"::1/128",         // loopback
+	"64:ff9b::/96",    // NAT64, could reach internal IPv4
+	"64:ff9b:1::/48",  // local-use NAT64
+	"100::/64",        // discard-only
+	"2001:db8::/32",   // documentation
+	"fc00::/7",        // unique local, also AWS IPv6 metadata fd00:ec2::254
+	"fe80::/10",       // link-local
+	"ff00::/8",        // multicast
+)
+
+func mustParsePrefixes(cidrs ...string) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		out = append(out, netip.MustParsePrefix(cidr))
+	}
+	return out
+}
+
+// IsBlockedIP tells whether ip belongs to a range a safe client refuses to
+// connect to
+func IsBlockedIP(ip netip.Addr) bool {
+	ip = ip.Unmap() // ::ffff:10.0.0.1 is 10.0.0.1
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeDialer checks addresses right before connecting, after DNS resolution,
+// so a host name resolving to a public address at validation time and to an
+// internal one at connection time (DNS rebinding) is still blocked
+type safeDialer struct {
+	allowHosts map[string]bool
+	allowCIDRs []netip.Prefix
+}
+
+func newSafeDialer(tc *TransportConfig) (*safeDialer, error) {
+	d := &safeDialer{allowHosts: map[string]bool{}}
+	for _, host := range tc.AllowHosts {
+		d.allowHosts[strings.ToLower(host)] = true
+	}
+	for _, cidr := range tc.AllowCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		d.allowCIDRs = append(d.allowCIDRs, prefix)
+	}
+	return d, nil
+}
+
+func (d *safeDialer) allowedIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range d.allowCIDRs {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return !IsBlockedIP(ip)
+}
+
+// control is used as net.Dialer Control, it is called with the resolved
+// address of every connection attempt
+func (d *safeDialer) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	if !d.allowedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
+
+// wrap returns a DialContext which skips the check for hosts in AllowHosts
+func (d *safeDialer) wrap(safe, plain func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && d.allowHosts[strings.ToLower(host)] {
+			return plain(ctx, network, addr)
+		}
+		return safe(ctx, network, addr)
+	}
+}
+
+// checkRedirect validates every redirect hop before following it, the dialer
+// checks the address again at connection time
+func (d *safeDialer) checkRedirect(req *nethttp.Request, via []*nethttp.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %s", ErrBlockedAddress, req.URL.Scheme)
+	}
+	host := req.URL.Hostname()
+	if d.allowHosts[strings.ToLower(host)] {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !d.allowedIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
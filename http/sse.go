@@ -0,0 +1,274 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	nethttp "net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/subiz/goutils/clock"
+)
+
+// Event is a message received from a Server-Sent Events stream, see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+type Event struct {
+	// last event ID seen on the stream, sent back in Last-Event-ID header when
+	// reconnecting
+	ID string
+
+	// event type, default to "message"
+	Event string
+
+	// event payload, lines of multi-line data are joined by "\n"
+	Data string
+
+	// reconnection delay requested by the server along with this event, zero
+	// if the event doesn't carry a retry field
+	Retry time.Duration
+}
+
+// errStreamClosed is used internally to tell the server closed the stream
+// normally, which should trigger a reconnection
+var errStreamClosed = errors.New("stream closed")
+
+// stopError wraps errors which must not trigger a reconnection
+type stopError struct{ err error }
+
+func (e *stopError) Error() string { return e.err.Error() }
+
+// Stream connects to a Server-Sent Events endpoint and calls onEvent for every
+// event received. It reconnects automatically with the Last-Event-ID header
+// when the connection drops, waiting for the delay requested by the server
+// (retry field) or backing off exponentially like Request does.
+//
+// Stream blocks until ctx is done, onEvent returns an error, the server
+// answers with 204 or a non retryable status code, or reconnecting keeps
+// failing for longer than config.Timeout (default 5 minutes). The returned
+// error is nil only when the server asked to stop with 204.
+func (me *Client) Stream(ctx context.Context, url string, config *Config, onEvent func(*Event) error) error {
+	timeout := 5 * time.Minute
+	if config != nil && config.Timeout > 0 {
+		timeout = config.Timeout
+	}
+
+	// streams are long-lived, the whole-call timeout of the client doesn't
+	// apply
+	client := *me.HttpClient
+	client.Timeout = 0
+
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = 60 * time.Second
+	bo.MaxElapsedTime = timeout
+	bo.Clock = clock.OrReal(me.Clock)
+	bo.Reset()
+
+	var lastEventID string
+	var retry time.Duration // reconnection delay requested by the server
+	for {
+		connected, err := streamOnce(ctx, &client, url, config, &lastEventID, &retry, onEvent)
+		if err == nil {
+			return nil
+		}
+		if stop, ok := err.(*stopError); ok {
+			return stop.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			bo.Reset()
+		}
+
+		next := bo.NextBackOff()
+		if next == backoff.Stop {
+			return err
+		}
+		if retry > 0 {
+			next = retry
+		}
+		t := clock.OrReal(me.Clock).NewTimer(next)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C():
+		}
+	}
+}
+
+// Events is like Stream but delivers events through a channel, which is
+// closed when the stream ends. The final error (nil when the server asked to
+// stop) is sent to the error channel right before the event channel is closed.
+func (me *Client) Events(ctx context.Context, url string, config *Config) (<-chan *Event, <-chan error) {
+	events := make(chan *Event)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(events)
+		errc <- me.Stream(ctx, url, config, func(ev *Event) error {
+			select {
+			case events <- ev:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return events, errc
+}
+
+// streamOnce makes a single connection and reads events until the stream
+// ends. connected tells whether the server accepted the stream, so backoff
+// can be reset.
+func streamOnce(ctx context.Context, client *nethttp.Client, url string, config *Config, lastEventID *string, retry *time.Duration, onEvent func(*Event) error) (connected bool, err error) {
+	req, err := nethttp.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, &stopError{err}
+	}
+	if config != nil {
+		for k, v := range config.Header {
+			req.Header.Set(k, v)
+		}
+	}
+	req.Header.Set("User-Agent", "Subiz-Gun/4.016")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if *lastEventID != "" {
+		req.Header.Set("Last-Event-ID", *lastEventID)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 204 {
+		return false, nil
+	}
+	if res.StatusCode == 429 || Is5xx(res.StatusCode) {
+		return false, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	if !Is2xx(res.StatusCode) {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		return false, &stopError{fmt.Errorf("unexpected status %d: %s", res.StatusCode, b)}
+	}
+	if mt, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); mt != "text/event-stream" {
+		return false, &stopError{fmt.Errorf("unexpected content type %s", res.Header.Get("Content-Type"))}
+	}
+
+	err = parseEvents(res.Body, lastEventID, retry, func(ev *Event) error {
+		if err := onEvent(ev); err != nil {
+			return &stopError{err}
+		}
+		return nil
+	})
+	return true, err
+}
+
+// parseEvents reads the event stream from r, calling onEvent for every
+// dispatched event. lastEventID and retry are updated as id and retry fields
+// are read, so they survive reconnections.
+func parseEvents(r io.Reader, lastEventID *string, retry *time.Duration, onEvent func(*Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	scanner.Split(scanEventLines)
+
+	var data bytes.Buffer
+	var eventType string
+	var eventRetry time.Duration
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff") // byte order mark
+			first = false
+		}
+
+		// blank line dispatches the event
+		if line == "" {
+			if data.Len() == 0 {
+				eventType, eventRetry = "", 0
+				continue
+			}
+			ev := &Event{
+				ID:    *lastEventID,
+				Event: eventType,
+				Data:  strings.TrimSuffix(data.String(), "\n"),
+				Retry: eventRetry,
+			}
+			if ev.Event == "" {
+				ev.Event = "message"
+			}
+			data.Reset()
+			eventType, eventRetry = "", 0
+			if err := onEvent(ev); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// comment
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				*lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				eventRetry = time.Duration(ms) * time.Millisecond
+				*retry = eventRetry
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// events not terminated by a blank line are discarded
+	return errStreamClosed
+}
+
+// scanEventLines is a bufio.SplitFunc splitting lines ended by CRLF, LF or a
+// lone CR
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	for i, b := range data {
+		if b == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if b == '\r' {
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if atEOF {
+				return i + 1, data[:i], nil
+			}
+			// need more data to know whether LF follows
+			return 0, nil, nil
+		}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
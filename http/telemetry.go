@@ -0,0 +1,226 @@
+package http
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// TraceContext identifies a span following W3C Trace Context, see
+// https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte // 0x01 means sampled
+}
+
+// String formats tc as a traceparent header value
+func (tc TraceContext) String() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" +
+		hex.EncodeToString(tc.SpanID[:]) + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// IsValid tells whether both trace id and span id are non zero
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// ParseTraceParent parses a traceparent header value, e.g:
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+func ParseTraceParent(s string) (TraceContext, error) {
+	var tc TraceContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || s[:2] == "ff" {
+		return tc, errors.New("invalid traceparent " + s)
+	}
+	// future versions may append fields, see section 3.2.4
+	if s[:2] == "00" && len(s) != 55 {
+		return tc, errors.New("invalid traceparent " + s)
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(tc.TraceID[:], []byte(s[3:35])); err != nil {
+		return tc, err
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(s[36:52])); err != nil {
+		return tc, err
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return tc, err
+	}
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return tc, errors.New("invalid traceparent " + s)
+	}
+	return tc, nil
+}
+
+// newSpanID returns a random non zero span id
+func newSpanID() [8]byte {
+	var id [8]byte
+	for id == [8]byte{} {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// childTrace returns trace context of a new span under parent, or of a new
+// sampled root span when parent is invalid
+func childTrace(parent TraceContext) TraceContext {
+	if !parent.IsValid() {
+		parent.Flags = 0x01
+		for parent.TraceID == [16]byte{} {
+			binary.BigEndian.PutUint64(parent.TraceID[:8], rand.Uint64())
+			binary.BigEndian.PutUint64(parent.TraceID[8:], rand.Uint64())
+		}
+	}
+	parent.SpanID = newSpanID()
+	return parent
+}
+
+type traceKey struct{}
+
+// ContextWithTrace returns a copy of ctx carrying tc, outbound requests made
+// with the returned context are sent as children of tc
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, tc)
+}
+
+// TraceFromContext returns the trace context carried by ctx
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// Span is a timed operation reported to a Tracer
+type Span interface {
+	SetAttribute(key string, value any)
+
+	// End finishes the span, err is the error of the operation if any
+	End(err error)
+}
+
+// Tracer receives spans of outbound calls. Each Request produces one span
+// covering all attempts, and one child span per attempt (including retries,
+// mirror failovers and hedges). Trace contexts are generated by this package
+// and propagated with the traceparent header, so any tracing backend can be
+// plugged in.
+type Tracer interface {
+	Start(ctx context.Context, name string, tc TraceContext, parent TraceContext) Span
+}
+
+// Metrics receives measurements of outbound calls
+type Metrics interface {
+	// ObserveAttempt is called after each attempt. statusClass is one of
+	// "1xx", "2xx", "3xx", "4xx", "5xx" or "error" when no response was
+	// received
+	ObserveAttempt(host, method, statusClass string, latency time.Duration, bytesOut, bytesIn int)
+
+	// IncRetry is called each time a request is retried after a 429 or 5xx
+	IncRetry(host, method string)
+}
+
+// telemetry holds the default Tracer and Metrics used by clients not having
+// their own
+type telemetry struct {
+	tracer  Tracer
+	metrics Metrics
+}
+
+var defaultTelemetry atomic.Pointer[telemetry]
+
+func init() { defaultTelemetry.Store(&telemetry{}) }
+
+// SetTelemetry sets the Tracer and Metrics used by all clients which don't
+// specify their own, including package level functions (Request, Get, ...).
+// Pass nil to disable.
+func SetTelemetry(tracer Tracer, metrics Metrics) {
+	defaultTelemetry.Store(&telemetry{tracer: tracer, metrics: metrics})
+}
+
+func (me *Client) tracer() Tracer {
+	if me.Tracer != nil {
+		return me.Tracer
+	}
+	return defaultTelemetry.Load().tracer
+}
+
+func (me *Client) metrics() Metrics {
+	if me.Metrics != nil {
+		return me.Metrics
+	}
+	return defaultTelemetry.Load().metrics
+}
+
+// observeAttempt reports an attempt to the metrics, out is the response body
+// or the error message when code <= 0
+func (me *Client) observeAttempt(method, url string, code int, latency time.Duration, bytesout int, out []byte) {
+	metrics := me.metrics()
+	if metrics == nil {
+		return
+	}
+	bytesin := 0
+	if code > 0 {
+		bytesin = len(out)
+	}
+	metrics.ObserveAttempt(hostOf(url), method, statusClass(code), latency, bytesout, bytesin)
+}
+
+// startSpan creates a child trace context of the one in ctx and reports it to
+// the tracer, the returned ctx carries the new trace context
+func (me *Client) startSpan(ctx context.Context, name, method, rawurl string) (context.Context, TraceContext, Span) {
+	parent, _ := TraceFromContext(ctx)
+	tc := childTrace(parent)
+	ctx = ContextWithTrace(ctx, tc)
+	tracer := me.tracer()
+	if tracer == nil {
+		return ctx, tc, nil
+	}
+	span := tracer.Start(ctx, name, tc, parent)
+	span.SetAttribute("http.request.method", method)
+	span.SetAttribute("url.full", rawurl)
+	span.SetAttribute("server.address", hostOf(rawurl))
+	return ctx, tc, span
+}
+
+// endSpan reports the outcome of a call to span, which may be nil
+func endSpan(span Span, code int, out []byte) {
+	if span == nil {
+		return
+	}
+	span.SetAttribute("http.response.status_code", code)
+	if code <= 0 {
+		span.End(errors.New(string(out)))
+		return
+	}
+	span.End(nil)
+}
+
+// endDownloadSpan reports the outcome of a download to span, which may be
+// nil, code is 0 when no response is received
+func endDownloadSpan(span Span, code int, err error) {
+	if span == nil {
+		return
+	}
+	span.SetAttribute("http.response.status_code", code)
+	span.End(err)
+}
+
+// statusClass groups status codes for metrics
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "error"
+	}
+	return string(rune('0'+code/100)) + "xx"
+}
+
+func hostOf(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
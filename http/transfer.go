@@ -0,0 +1,605 @@
+package http
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	nethttp "net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/subiz/goutils/clock"
+)
+
+// ErrChecksumMismatch is returned when downloaded content doesn't match
+// DownloadOptions.Checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrContentChanged is returned when resuming a download but the remote
+// content has changed since the first attempt
+var ErrContentChanged = errors.New("content changed while downloading")
+
+// Progress is called as bytes are transferred, total is -1 when unknown
+type Progress func(done, total int64)
+
+// FormFile is a file part of a multipart upload
+type FormFile struct {
+	FieldName   string
+	FileName    string
+	ContentType string // default application/octet-stream
+
+	// Open returns the file content, it is called again on every retry. If
+	// the returned reader is an io.Closer, it is closed after use
+	Open func() (io.Reader, error)
+
+	// content length, used to report progress, 0 if unknown
+	Size int64
+}
+
+// FileFromPath creates a FormFile reading the file at path
+func FileFromPath(fieldName, path string) (*FormFile, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FormFile{
+		FieldName: fieldName,
+		FileName:  stat.Name(),
+		Size:      stat.Size(),
+		Open:      func() (io.Reader, error) { return os.Open(path) },
+	}, nil
+}
+
+// Upload sends fields and files as a multipart/form-data body. Files are
+// streamed from their readers, so they are never loaded in memory entirely.
+// Like Request, it retries on 429 or 5xx with exponential backoff, reopening
+// the files, since multipart bodies cannot be resumed, reports spans and
+// metrics and authorizes with config.Auth. Only Header, Timeout and Auth of
+// config are used: uploads are never cached, compressed or sent to
+// BaseURLs. progress may be nil.
+func (me *Client) Upload(ctx context.Context, method, url string, fields map[string]string, files []*FormFile, config *Config, progress Progress) ([]byte, int, nethttp.Header) {
+	ctx, _, span := me.startSpan(ctx, "HTTP "+method, method, url)
+	timeout := 5 * time.Minute
+	var header map[string]string
+	if config != nil {
+		header = config.Header
+		if config.Timeout > 0 {
+			timeout = config.Timeout
+		}
+	}
+
+	var total int64
+	for _, f := range files {
+		if f.Size <= 0 {
+			total = -1
+			break
+		}
+		total += f.Size
+	}
+
+	send := func(header map[string]string) ([]byte, int, nethttp.Header) {
+		var out []byte
+		var code int
+		var respheader nethttp.Header
+		bo := backoff.NewExponentialBackOff()
+		bo.MaxInterval = 60 * time.Second
+		bo.MaxElapsedTime = timeout
+		bo.Clock = clock.OrReal(me.Clock)
+		bo.Reset()
+		retried := false
+		err := backoff.Retry(func() error {
+			if retried {
+				if metrics := me.metrics(); metrics != nil {
+					metrics.IncRetry(hostOf(url), method)
+				}
+			}
+			retried = true
+			out, code, respheader = me.uploadOnce(ctx, method, url, header, fields, files, total, progress)
+			if code == 429 || Is5xx(code) {
+				return errors.New("retry")
+			}
+			return nil
+		}, backoff.WithContext(bo, ctx))
+		if err != nil {
+			return out, -2, respheader
+		}
+		return out, code, respheader
+	}
+	if config != nil && config.Auth != nil {
+		send = me.withAuth(ctx, config.Auth, send)
+	}
+	out, code, respheader := send(header)
+	endSpan(span, code, out)
+	return out, code, respheader
+}
+
+// uploadOnce sends the multipart body once, reporting a span and metrics like
+// attempt
+func (me *Client) uploadOnce(ctx context.Context, method, url string, header map[string]string, fields map[string]string, files []*FormFile, total int64, progress Progress) ([]byte, int, nethttp.Header) {
+	ctx, tc, span := me.startSpan(ctx, "HTTP "+method+" attempt", method, url)
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeMultipart(mw, fields, files, total, progress))
+	}()
+	defer pr.Close()
+
+	body := &countReader{r: pr}
+	clk := clock.OrReal(me.Clock)
+	start := clk.Now()
+	out, code, respheader := me.sendMultipart(ctx, method, url, header, mw.FormDataContentType(), tc, body)
+	me.observeAttempt(method, url, code, clk.Now().Sub(start), int(body.n.Load()), out)
+	endSpan(span, code, out)
+	return out, code, respheader
+}
+
+func (me *Client) sendMultipart(ctx context.Context, method, url string, header map[string]string, contenttype string, tc TraceContext, body io.Reader) ([]byte, int, nethttp.Header) {
+	req, err := nethttp.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return []byte(err.Error()), -1, nil
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("User-Agent", "Subiz-Gun/4.016")
+	req.Header.Set("Content-Type", contenttype)
+	req.Header.Set("Traceparent", tc.String())
+
+	// an upload may take longer than the client timeout, the overall
+	// timeout is enforced by the backoff
+	client := *me.HttpClient
+	client.Timeout = 0
+	res, err := client.Do(req)
+	if err != nil {
+		return []byte(err.Error()), 0, nil
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return []byte(err.Error()), -5, nil
+	}
+	return b, res.StatusCode, res.Header
+}
+
+func writeMultipart(mw *multipart.Writer, fields map[string]string, files []*FormFile, total int64, progress Progress) error {
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+
+	var done int64
+	for _, f := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(f.FieldName), escapeQuotes(f.FileName)))
+		contenttype := f.ContentType
+		if contenttype == "" {
+			contenttype = "application/octet-stream"
+		}
+		h.Set("Content-Type", contenttype)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(part, &progressReader{r: r, done: &done, total: total, progress: progress})
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if f.Size > 0 && n != f.Size {
+			return fmt.Errorf("%s: read %d bytes, expected %d", f.FileName, n, f.Size)
+		}
+	}
+	return mw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string { return quoteEscaper.Replace(s) }
+
+// progressReader reports bytes read through progress
+type progressReader struct {
+	r        io.Reader
+	done     *int64
+	total    int64
+	progress Progress
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 && pr.progress != nil {
+		*pr.done += int64(n)
+		pr.progress(*pr.done, pr.total)
+	}
+	return n, err
+}
+
+// countReader counts bytes read, reported as transferred bytes in metrics.
+// The transport may still be reading a request body when the response
+// arrives, so n is atomic.
+type countReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
+
+// DownloadOptions used to specify how to download a file
+type DownloadOptions struct {
+	// HTTP header entries to be injected to the requests
+	Header map[string]string
+
+	// maximum amount of time for the download, including retries, default
+	// 30 minutes
+	Timeout time.Duration
+
+	// expected checksum of the content formatted as algorithm:hex, e.g:
+	// "sha256:9f86d081884c7d65...". Supported algorithms are md5, sha1,
+	// sha256 and sha512
+	Checksum string
+
+	// called as bytes are received
+	Progress Progress
+}
+
+// Download writes the content at url to w. When the connection drops, it
+// resumes from the last byte received using a Range request, guarded by
+// If-Range so a changed content is never stitched to the old one. Servers not
+// supporting ranges send the whole content again, the bytes already written
+// are skipped. Retries back off exponentially and spans and metrics are
+// reported like Request, downloads are never cached and have no Auth, set
+// the Authorization header in opts.Header.
+// It returns the number of bytes written.
+func (me *Client) Download(ctx context.Context, url string, w io.Writer, opts *DownloadOptions) (int64, error) {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	h, expected, err := parseChecksum(opts.Checksum)
+	if err != nil {
+		return 0, err
+	}
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+	d := &download{client: me, url: url, opts: opts, w: w, total: -1}
+	if err := d.run(ctx); err != nil {
+		return d.offset, err
+	}
+	if h != nil && hex.EncodeToString(h.Sum(nil)) != expected {
+		return d.offset, ErrChecksumMismatch
+	}
+	return d.offset, nil
+}
+
+// DownloadFile downloads url to the file at path. Content is written to
+// path + ".part" first, which is resumed by later calls if the process dies,
+// then renamed to path once complete and verified.
+func (me *Client) DownloadFile(ctx context.Context, url, path string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	h, expected, err := parseChecksum(opts.Checksum)
+	if err != nil {
+		return err
+	}
+
+	partpath := path + ".part"
+	f, err := os.OpenFile(partpath, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// rehash what was downloaded by a previous call
+	var offset int64
+	if h != nil {
+		if offset, err = io.Copy(h, f); err != nil {
+			return err
+		}
+	} else if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+	// the validator of the partial content is kept next to it, so a later
+	// call only resumes if the remote content is still the same
+	validatorpath := partpath + ".validator"
+	validator, _ := os.ReadFile(validatorpath)
+	d := &download{client: me, url: url, opts: opts, w: w, offset: offset, total: -1,
+		validator: string(validator),
+		saveValidator: func(validator string) {
+			os.WriteFile(validatorpath, []byte(validator), 0o644)
+		},
+		restart: func() error {
+			if h != nil {
+				h.Reset()
+			}
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			_, err := f.Seek(0, io.SeekStart)
+			return err
+		}}
+	if offset > 0 && d.validator == "" {
+		// can't tell whether the partial content is still valid
+		if err := d.restart(); err != nil {
+			return err
+		}
+		d.offset = 0
+	}
+	if err := d.run(ctx); err != nil {
+		return err
+	}
+	os.Remove(validatorpath)
+	if h != nil && hex.EncodeToString(h.Sum(nil)) != expected {
+		os.Remove(partpath)
+		return ErrChecksumMismatch
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partpath, path)
+}
+
+func parseChecksum(checksum string) (hash.Hash, string, error) {
+	if checksum == "" {
+		return nil, "", nil
+	}
+	algo, sum, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return nil, "", fmt.Errorf("invalid checksum %s, must be algorithm:hex", checksum)
+	}
+	sum = strings.ToLower(sum)
+	switch strings.ToLower(algo) {
+	case "md5":
+		return md5.New(), sum, nil
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "sha256":
+		return sha256.New(), sum, nil
+	case "sha512":
+		return sha512.New(), sum, nil
+	}
+	return nil, "", fmt.Errorf("unsupported checksum algorithm %s", algo)
+}
+
+// download holds the state of a resumable download between attempts
+type download struct {
+	client *Client
+	url    string
+	opts   *DownloadOptions
+	w      io.Writer
+
+	offset    int64  // number of bytes written to w
+	total     int64  // content length, -1 if unknown
+	validator string // ETag or Last-Modified of the content being downloaded
+
+	// restart truncates what was written, nil if w can't be truncated
+	restart func() error
+
+	// persists the validator, may be nil
+	saveValidator func(validator string)
+
+	code     int   // status code of the last attempt, 0 if no response
+	received int64 // bytes of the body received by the last attempt
+}
+
+func (d *download) run(ctx context.Context) error {
+	timeout := 30 * time.Minute
+	if d.opts.Timeout > 0 {
+		timeout = d.opts.Timeout
+	}
+	bo := backoff.NewExponentialBackOff()
+	bo.MaxInterval = 60 * time.Second
+	bo.MaxElapsedTime = timeout
+	bo.Clock = clock.OrReal(d.client.Clock)
+	bo.Reset()
+	ctx, _, span := d.client.startSpan(ctx, "HTTP GET", "GET", d.url)
+	retried := false
+	err := backoff.Retry(func() error {
+		if retried {
+			if metrics := d.client.metrics(); metrics != nil {
+				metrics.IncRetry(hostOf(d.url), "GET")
+			}
+		}
+		retried = true
+		before := d.offset
+		err := d.attempt(ctx)
+		if d.offset > before {
+			// made progress, don't give up on a slow but working server
+			bo.Reset()
+		}
+		return err
+	}, backoff.WithContext(bo, ctx))
+	endDownloadSpan(span, d.code, err)
+	return err
+}
+
+// attempt calls once, reporting a span and metrics like Client.attempt
+func (d *download) attempt(ctx context.Context) error {
+	ctx, tc, span := d.client.startSpan(ctx, "HTTP GET attempt", "GET", d.url)
+	d.code, d.received = 0, 0
+	clk := clock.OrReal(d.client.Clock)
+	start := clk.Now()
+	err := d.once(ctx, tc)
+	if metrics := d.client.metrics(); metrics != nil {
+		metrics.ObserveAttempt(hostOf(d.url), "GET", statusClass(d.code), clk.Now().Sub(start), 0, int(d.received))
+	}
+	endDownloadSpan(span, d.code, err)
+	return err
+}
+
+// once makes a single request, it returns a *backoff.PermanentError for
+// errors which retrying won't fix
+func (d *download) once(ctx context.Context, tc TraceContext) error {
+	req, err := nethttp.NewRequestWithContext(ctx, "GET", d.url, nil)
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	for k, v := range d.opts.Header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("User-Agent", "Subiz-Gun/4.016")
+	req.Header.Set("Traceparent", tc.String())
+	if d.offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(d.offset, 10)+"-")
+		if d.validator != "" {
+			req.Header.Set("If-Range", d.validator)
+		}
+	}
+
+	// a transfer may take longer than the client timeout, the overall
+	// timeout is enforced by the backoff
+	client := *d.client.HttpClient
+	client.Timeout = 0
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	d.code = res.StatusCode
+	body := &countReader{r: res.Body}
+	defer func() { d.received = body.n.Load() }()
+
+	validator := res.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		// weak etags are not allowed in If-Range
+		validator = res.Header.Get("Last-Modified")
+	}
+
+	skip := int64(0) // bytes of the body already written
+	switch {
+	case res.StatusCode == 206:
+		start, total, err := parseContentRange(res.Header.Get("Content-Range"))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		if start != d.offset {
+			return backoff.Permanent(fmt.Errorf("server resumed from %d, expected %d", start, d.offset))
+		}
+		d.total = total
+	case res.StatusCode == 416 && d.offset > 0:
+		// we already have everything
+		if _, total, err := parseContentRange(res.Header.Get("Content-Range")); err == nil && total == d.offset {
+			return nil
+		}
+		return backoff.Permanent(fmt.Errorf("range not satisfiable at %d", d.offset))
+	case Is2xx(res.StatusCode):
+		d.total = res.ContentLength
+		if d.offset > 0 {
+			// range ignored or If-Range didn't match
+			changed := d.validator != "" && validator != d.validator
+			if (changed || d.validator == "") && d.restart != nil {
+				if err := d.restart(); err != nil {
+					return backoff.Permanent(err)
+				}
+				d.offset = 0
+			} else if changed {
+				return backoff.Permanent(ErrContentChanged)
+			} else {
+				skip = d.offset
+			}
+		}
+	case res.StatusCode == 429 || Is5xx(res.StatusCode):
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	default:
+		b, _ := io.ReadAll(io.LimitReader(body, 4096))
+		return backoff.Permanent(fmt.Errorf("unexpected status %d: %s", res.StatusCode, b))
+	}
+	if validator != d.validator && d.saveValidator != nil {
+		d.saveValidator(validator)
+	}
+	d.validator = validator
+
+	if skip > 0 {
+		if _, err := io.CopyN(io.Discard, body, skip); err != nil {
+			return err
+		}
+	}
+
+	var r io.Reader = body
+	if d.opts.Progress != nil {
+		r = &progressReader{r: r, done: &d.offset, total: d.total, progress: d.opts.Progress}
+		d.opts.Progress(d.offset, d.total)
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if _, err := d.w.Write(buf[:n]); err != nil {
+				return backoff.Permanent(err)
+			}
+			if d.opts.Progress == nil {
+				d.offset += int64(n)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	if d.total >= 0 && d.offset != d.total {
+		return fmt.Errorf("short read, got %d of %d bytes", d.offset, d.total)
+	}
+	return nil
+}
+
+// parseContentRange parses "bytes 100-199/1000", total is -1 when unknown
+// ("bytes 100-199/*"). Unsatisfied ranges ("bytes */1000") have start -1
+func parseContentRange(s string) (start, total int64, err error) {
+	unit, rest, ok := strings.Cut(s, " ")
+	if !ok || unit != "bytes" {
+		return 0, 0, fmt.Errorf("invalid content range %s", s)
+	}
+	rng, size, ok := strings.Cut(rest, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range %s", s)
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid content range %s", s)
+		}
+	}
+	if rng == "*" {
+		return -1, total, nil
+	}
+	first, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid content range %s", s)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid content range %s", s)
+	}
+	return start, total, nil
+}
@@ -3,9 +3,33 @@ package loop
 import (
 	"fmt"
 	"time"
+
+	"github.com/subiz/goutils/clock"
 )
 
-func Loop(f func()) {
+// defaultDelay is the wait between retries of Loop
+const defaultDelay = 3 * time.Second
+
+// Looper retries functions until they succeed, see Loop
+type Looper struct {
+	Clock clock.Clock   // waits between retries, default to clock.Real
+	Delay time.Duration // wait between retries, default 3 seconds
+}
+
+// Loop calls f until it returns without panicking, waiting 3 seconds
+// between calls
+func Loop(f func()) { (&Looper{}).Loop(f) }
+
+// LoopErr calls f until it returns nil, at most maxtime times (unlimited when
+// maxtime <= 0), waiting 3 seconds between calls. The last error is returned.
+func LoopErr(f func() error, maxtime int) error { return (&Looper{}).LoopErr(f, maxtime) }
+
+// Loop calls f until it returns without panicking
+func (l *Looper) Loop(f func()) {
+	delay := l.Delay
+	if delay <= 0 {
+		delay = defaultDelay
+	}
 	for {
 		err := func() (e error) {
 			defer func() {
@@ -25,18 +49,20 @@ func Loop(f func()) {
 		if err == nil {
 			break
 		}
-		fmt.Println("will retries in 3 sec")
-		time.Sleep(3 * time.Second)
+		fmt.Println("will retries in", delay)
+		clock.OrReal(l.Clock).Sleep(delay)
 	}
 }
 
-func LoopErr(f func() error, maxtime int) error {
+// LoopErr calls f until it returns nil, at most maxtime times (unlimited when
+// maxtime <= 0). The last error is returned.
+func (l *Looper) LoopErr(f func() error, maxtime int) error {
 	if maxtime <= 0 {
 		maxtime = 1000_000_000
 	}
 	i := 0
 	var lasterr error
-	Loop(func() {
+	l.Loop(func() {
 		i++
 		if i > maxtime {
 			return
@@ -0,0 +1,45 @@
+package loop
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/subiz/goutils/clock"
+)
+
+func TestLooper(t *testing.T) {
+	c := clock.NewFakeClock(time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	l := &Looper{Clock: c}
+
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		l.Loop(func() {
+			calls++
+			if calls < 3 {
+				panic("not yet")
+			}
+		})
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		c.BlockUntil(1)
+		c.Advance(3 * time.Second)
+	}
+	<-done
+	if calls != 3 {
+		t.Errorf("should call 3 times, got %d", calls)
+	}
+
+	l.Delay = time.Minute
+	errc := make(chan error)
+	go func() { errc <- l.LoopErr(func() error { return errors.New("down") }, 2) }()
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	if err := <-errc; err == nil || err.Error() != "down" {
+		t.Errorf("should return the last error, got %v", err)
+	}
+}